@@ -2,15 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/teivah/gossip-glomers/payload"
 )
 
 func main() {
 	n := maelstrom.NewNode()
-	s := &server{n: n}
+	s := &server{n: n, ids: make(map[int]struct{})}
 
 	n.Handle("broadcast", s.broadcastHandler)
 	n.Handle("read", s.readHandler)
@@ -25,7 +27,9 @@ type server struct {
 	n *maelstrom.Node
 
 	idsMu sync.RWMutex
-	ids   []int
+	ids   map[int]struct{}
+	// Append-only log of the values, used by the incremental reads
+	messages []any
 
 	topologyMu      sync.RWMutex
 	currentTopology map[string][]string
@@ -37,8 +41,16 @@ func (s *server) broadcastHandler(msg maelstrom.Message) error {
 		return err
 	}
 
+	id, err := payload.ID(body)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+
 	s.idsMu.Lock()
-	s.ids = append(s.ids, int(body["message"].(float64)))
+	if _, exists := s.ids[id]; !exists {
+		s.ids[id] = struct{}{}
+		s.messages = append(s.messages, body["message"])
+	}
 	s.idsMu.Unlock()
 
 	return s.n.Reply(msg, map[string]any{
@@ -46,18 +58,42 @@ func (s *server) broadcastHandler(msg maelstrom.Message) error {
 	})
 }
 
+type readMsg struct {
+	Cursor *int `json:"cursor"`
+}
+
+// readHandler returns all the values or, if a cursor is provided, only the
+// values received since this cursor along with a new cursor (a position in
+// messages, which is append-only).
 func (s *server) readHandler(msg maelstrom.Message) error {
+	var body readMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	cursor := 0
+	if body.Cursor != nil {
+		cursor = *body.Cursor
+	}
+
 	s.idsMu.RLock()
-	ids := make([]int, len(s.ids))
-	for i := 0; i < len(s.ids); i++ {
-		ids[i] = s.ids[i]
+	if cursor < 0 || cursor > len(s.messages) {
+		s.idsMu.RUnlock()
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("invalid cursor: %d", cursor))
 	}
+	messages := make([]any, len(s.messages)-cursor)
+	copy(messages, s.messages[cursor:])
+	next := len(s.messages)
 	s.idsMu.RUnlock()
 
-	return s.n.Reply(msg, map[string]any{
+	res := map[string]any{
 		"type":     "read_ok",
-		"messages": ids,
-	})
+		"messages": messages,
+	}
+	if body.Cursor != nil {
+		res["cursor"] = next
+	}
+	return s.n.Reply(msg, res)
 }
 
 type topologyMsg struct {
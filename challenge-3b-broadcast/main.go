@@ -2,15 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/teivah/gossip-glomers/payload"
 )
 
 func main() {
 	n := maelstrom.NewNode()
-	s := &server{n: n, nodeID: n.ID(), ids: make(map[int]struct{})}
+	s := &server{n: n, nodeID: n.ID(), ids: make(map[int]struct{}), values: make(map[int]any)}
 
 	n.Handle("broadcast", s.broadcastHandler)
 	n.Handle("read", s.readHandler)
@@ -27,6 +29,10 @@ type server struct {
 
 	idsMu sync.RWMutex
 	ids   map[int]struct{}
+	// Append-only arrival log of the ids, used by the incremental reads
+	arrivals []int
+	// Values that aren't their own ID
+	values map[int]any
 }
 
 func (s *server) broadcastHandler(msg maelstrom.Message) error {
@@ -35,13 +41,20 @@ func (s *server) broadcastHandler(msg maelstrom.Message) error {
 		return err
 	}
 
-	id := int(body["message"].(float64))
+	id, err := payload.ID(body)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
 	s.idsMu.Lock()
 	if _, exists := s.ids[id]; exists {
 		s.idsMu.Unlock()
 		return nil
 	}
 	s.ids[id] = struct{}{}
+	s.arrivals = append(s.arrivals, id)
+	if message := body["message"]; !payload.Inline(message, id) {
+		s.values[id] = message
+	}
 	s.idsMu.Unlock()
 
 	if err := s.broadcast(msg.Src, body); err != nil {
@@ -69,26 +82,77 @@ func (s *server) broadcast(src string, body map[string]any) error {
 	return nil
 }
 
+type readMsg struct {
+	Cursor *int `json:"cursor"`
+}
+
+// readHandler returns all the ids or, if a cursor is provided, only the ids
+// learned since this cursor along with a new cursor. A cursor is a position in
+// the arrival log of the node, so it's only valid for the node that returned
+// it.
 func (s *server) readHandler(msg maelstrom.Message) error {
-	ids := s.getAllIDs()
+	var body readMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	if body.Cursor == nil {
+		return s.n.Reply(msg, map[string]any{
+			"type":     "read_ok",
+			"messages": s.messages(s.getAllIDs()),
+		})
+	}
 
+	ids, cursor, err := s.getIDsSince(*body.Cursor)
+	if err != nil {
+		return err
+	}
 	return s.n.Reply(msg, map[string]any{
 		"type":     "read_ok",
-		"messages": ids,
+		"messages": s.messages(ids),
+		"cursor":   cursor,
 	})
 }
 
-func (s *server) getAllIDs() []int {
+// messages returns the values of ids.
+func (s *server) messages(ids []int) []any {
 	s.idsMu.RLock()
-	ids := make([]int, 0, len(s.ids))
-	for id := range s.ids {
-		ids = append(ids, id)
+	defer s.idsMu.RUnlock()
+
+	res := make([]any, len(ids))
+	for i, id := range ids {
+		if v, exists := s.values[id]; exists {
+			res[i] = v
+		} else {
+			res[i] = id
+		}
 	}
+	return res
+}
+
+func (s *server) getAllIDs() []int {
+	s.idsMu.RLock()
+	ids := make([]int, len(s.arrivals))
+	copy(ids, s.arrivals)
 	s.idsMu.RUnlock()
 
 	return ids
 }
 
+// getIDsSince returns the ids learned since a cursor, along with the new
+// cursor.
+func (s *server) getIDsSince(cursor int) ([]int, int, error) {
+	s.idsMu.RLock()
+	defer s.idsMu.RUnlock()
+
+	if cursor < 0 || cursor > len(s.arrivals) {
+		return nil, 0, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("invalid cursor: %d", cursor))
+	}
+	ids := make([]int, len(s.arrivals)-cursor)
+	copy(ids, s.arrivals[cursor:])
+	return ids, len(s.arrivals), nil
+}
+
 func (s *server) topologyHandler(msg maelstrom.Message) error {
 	return s.n.Reply(msg, map[string]any{
 		"type": "topology_ok",
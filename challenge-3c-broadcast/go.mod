@@ -2,4 +2,9 @@ module github.com/teivah/gossip-glomers/challenge-3c-broadcast
 
 go 1.20
 
-require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20230113211434-22f433519054
+require (
+	github.com/jepsen-io/maelstrom/demo/go v0.0.0-20230113211434-22f433519054
+	github.com/teivah/gossip-glomers/payload v0.0.0
+)
+
+replace github.com/teivah/gossip-glomers/payload => ../payload
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/payload"
+)
+
+// A message is only retried maxRetry times, so it can be lost for a neighbor
+// after a long partition. To guarantee convergence, each node periodically
+// compares its ids with one of its neighbors (round-robin), exchanging only the
+// ids one side is missing:
+//   - The hash space is split into digestFanout ranges, and the digest of a
+//     range is its number of ids and the sum of their hashes
+//   - The neighbor compares the digests with its own ones. A mismatching range
+//     is split again in the next round, until the side with fewer ids in it has
+//     at most leafSize of them: this side then sends its ids in the range, and
+//     the other side replies with (or pushes back) only the ids it's missing
+//
+// The ids learned this way are then broadcast like any other id. The values
+// that aren't their own ID are sent along in a payloads field.
+
+const (
+	antiEntropyFrequency = 2 * time.Second
+	digestFanout         = 16
+	digestBits           = 4
+	leafSize             = 8
+	// The prefixes of the ranges stay below 2^53 so that JSON keeps them exact
+	maxDepth = 12
+)
+
+// hashRange is the range of the ids whose hash starts with the depth *
+// digestBits bits of prefix.
+type hashRange struct {
+	Depth  int    `json:"depth"`
+	Prefix uint64 `json:"prefix"`
+}
+
+func (r hashRange) contains(h uint64) bool {
+	return r.Depth == 0 || h>>(64-digestBits*r.Depth) == r.Prefix
+}
+
+// sub returns the index of the sub-range of h.
+func (r hashRange) sub(h uint64) int {
+	return int(h>>(64-digestBits*(r.Depth+1))) & (digestFanout - 1)
+}
+
+func (r hashRange) split(i int) hashRange {
+	return hashRange{Depth: r.Depth + 1, Prefix: r.Prefix<<digestBits | uint64(i)}
+}
+
+// digest is the [count, checksum] of each sub-range of a range.
+type digest [digestFanout][2]uint32
+
+type syncMsg struct {
+	Ranges  []hashRange `json:"ranges"`
+	Digests []digest    `json:"digests"`
+	// All our ids in the leaves requested by the peer
+	Leaves   []hashRange `json:"leaves"`
+	Messages []int       `json:"messages"`
+	Payloads map[int]any `json:"payloads"`
+}
+
+type syncOkMsg struct {
+	// Sub-ranges to compare in the next round
+	Ranges []hashRange `json:"ranges"`
+	// Leaves whose ids are requested in the next round
+	Want []hashRange `json:"want"`
+	// Leaves whose ids are all part of messages, along with the ids the peer
+	// is missing in the leaves it sent
+	Leaves   []hashRange `json:"leaves"`
+	Messages []int       `json:"messages"`
+	Payloads map[int]any `json:"payloads"`
+}
+
+type syncPushMsg struct {
+	Messages []int       `json:"messages"`
+	Payloads map[int]any `json:"payloads"`
+}
+
+func (s *server) antiEntropy() {
+	peer, exists := s.nextSyncPeer()
+	if !exists {
+		return
+	}
+
+	ranges := []hashRange{{}}
+	var leaves []hashRange
+	messages := make([]int, 0)
+	for round := 0; (len(ranges) != 0 || len(leaves) != 0) && round <= maxDepth+1; round++ {
+		body, ok := s.syncRound(peer, ranges, leaves, messages)
+		if !ok {
+			return
+		}
+		s.merge(peer, body.Messages, body.Payloads)
+
+		missing := s.missing(body.Leaves, body.Messages)
+		if len(missing) != 0 {
+			if err := s.rpc(peer, map[string]any{
+				"type":     "sync_push",
+				"messages": missing,
+				"payloads": s.payloads(missing),
+			}); err != nil {
+				log.Warnf("failed to push missing ids to %s: %v", peer, err)
+			}
+		}
+
+		ranges = body.Ranges
+		leaves = body.Want
+		messages = s.idsIn(leaves)
+	}
+}
+
+// syncRound sends the digests of ranges along with our ids in leaves.
+func (s *server) syncRound(peer string, ranges, leaves []hashRange, messages []int) (syncOkMsg, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := s.n.SyncRPC(ctx, peer, map[string]any{
+		"type":     "sync",
+		"ranges":   ranges,
+		"digests":  s.digests(ranges),
+		"leaves":   leaves,
+		"messages": messages,
+		"payloads": s.payloads(messages),
+	})
+	if err != nil {
+		s.markDown(peer)
+		log.Warnf("failed to sync with %s: %v", peer, err)
+		return syncOkMsg{}, false
+	}
+	s.markUp(peer)
+
+	var body syncOkMsg
+	if err := json.Unmarshal(res.Body, &body); err != nil {
+		log.Error(err)
+		return syncOkMsg{}, false
+	}
+	return body, true
+}
+
+// nextSyncPeer returns the next neighbor to sync with. It's only called from
+// the anti-entropy goroutine.
+func (s *server) nextSyncPeer() (string, bool) {
+	s.nodesMu.RLock()
+	neighbors := s.graph.Neighbors(s.nodeID)
+	s.nodesMu.RUnlock()
+
+	if len(neighbors) == 0 {
+		return "", false
+	}
+	s.syncPeer = (s.syncPeer + 1) % len(neighbors)
+	return neighbors[s.syncPeer], true
+}
+
+func (s *server) syncHandler(msg maelstrom.Message) error {
+	var body syncMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if len(body.Digests) != len(body.Ranges) {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, "one digest per range expected")
+	}
+	for _, r := range append(body.Ranges, body.Leaves...) {
+		if r.Depth < 0 || r.Depth > maxDepth {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, "invalid range depth")
+		}
+	}
+	s.merge(msg.Src, body.Messages, body.Payloads)
+
+	// The ids the peer is missing in the leaves it sent
+	messages := s.missing(body.Leaves, body.Messages)
+
+	local := s.digests(body.Ranges)
+	var ranges, want, leaves []hashRange
+	for i, r := range body.Ranges {
+		for j := range local[i] {
+			if local[i][j] == body.Digests[i][j] {
+				continue
+			}
+			sub := r.split(j)
+			ours, theirs := int(local[i][j][0]), int(body.Digests[i][j][0])
+			switch {
+			case ours <= theirs && (ours <= leafSize || sub.Depth == maxDepth):
+				leaves = append(leaves, sub)
+			case theirs <= leafSize || sub.Depth == maxDepth:
+				want = append(want, sub)
+			default:
+				ranges = append(ranges, sub)
+			}
+		}
+	}
+	messages = append(messages, s.idsIn(leaves)...)
+
+	return s.n.Reply(msg, map[string]any{
+		"type":     "sync_ok",
+		"ranges":   ranges,
+		"want":     want,
+		"leaves":   leaves,
+		"messages": messages,
+		"payloads": s.payloads(messages),
+	})
+}
+
+func (s *server) syncPushHandler(msg maelstrom.Message) error {
+	var body syncPushMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	s.merge(msg.Src, body.Messages, body.Payloads)
+
+	return s.n.Reply(msg, map[string]any{
+		"type": "sync_push_ok",
+	})
+}
+
+// digests returns the digests of the sub-ranges of each range.
+func (s *server) digests(ranges []hashRange) []digest {
+	res := make([]digest, len(ranges))
+	if len(ranges) == 0 {
+		return res
+	}
+
+	s.idsMu.RLock()
+	for id := range s.ids {
+		h := hash(id)
+		for i, r := range ranges {
+			if r.contains(h) {
+				sub := r.sub(h)
+				res[i][sub][0]++
+				res[i][sub][1] += uint32(h)
+				break
+			}
+		}
+	}
+	s.idsMu.RUnlock()
+	return res
+}
+
+// idsIn returns the local ids in the given ranges.
+func (s *server) idsIn(ranges []hashRange) []int {
+	ids := make([]int, 0)
+	if len(ranges) == 0 {
+		return ids
+	}
+	s.idsMu.RLock()
+	for id := range s.ids {
+		h := hash(id)
+		for _, r := range ranges {
+			if r.contains(h) {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	s.idsMu.RUnlock()
+	return ids
+}
+
+// merge stores the ids received from src and broadcasts the unknown ones.
+func (s *server) merge(src string, messages []int, payloads map[int]any) {
+	var unknown []int
+	s.idsMu.Lock()
+	for _, message := range messages {
+		if _, exists := s.ids[message]; exists {
+			continue
+		}
+		s.ids[message] = struct{}{}
+		s.arrivals = append(s.arrivals, message)
+		if v, exists := payloads[message]; exists {
+			s.values[message] = v
+		}
+		unknown = append(unknown, message)
+	}
+	s.idsMu.Unlock()
+
+	for _, message := range unknown {
+		body := map[string]any{
+			"type":    "broadcast",
+			"message": message,
+		}
+		if v, exists := payloads[message]; exists {
+			body["message"] = v
+			body[payload.KeyField] = message
+		}
+		if err := s.broadcast(src, body); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// payloads returns the values of the ids that aren't their own ID.
+func (s *server) payloads(ids []int) map[int]any {
+	s.idsMu.RLock()
+	defer s.idsMu.RUnlock()
+
+	res := make(map[int]any)
+	for _, id := range ids {
+		if v, exists := s.values[id]; exists {
+			res[id] = v
+		}
+	}
+	return res
+}
+
+// missing returns the local ids in the given ranges that the peer is missing,
+// known being all its ids in these ranges.
+func (s *server) missing(ranges []hashRange, known []int) []int {
+	if len(ranges) == 0 {
+		return nil
+	}
+	knownSet := make(map[int]bool, len(known))
+	for _, id := range known {
+		knownSet[id] = true
+	}
+
+	var missing []int
+	for _, id := range s.idsIn(ranges) {
+		if !knownSet[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// hash is the fmix64 finalizer of MurmurHash3.
+func hash(id int) uint64 {
+	x := uint64(id)
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
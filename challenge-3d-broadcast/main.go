@@ -9,9 +9,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/emirpasic/gods/trees/btree"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/payload"
+	"github.com/teivah/gossip-glomers/topology"
 )
 
 const maxRetry = 100
@@ -25,13 +26,33 @@ func init() {
 }
 
 func main() {
+	strategy, err := topology.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	q, err := quorumFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	n := maelstrom.NewNode()
-	s := &server{n: n, ids: make(map[int]struct{})}
+	s := &server{n: n, ids: make(map[int]struct{}), values: make(map[int]any), strategy: strategy, down: make(map[string]struct{}), quorum: q}
 
 	n.Handle("init", s.initHandler)
 	n.Handle("broadcast", s.broadcastHandler)
 	n.Handle("read", s.readHandler)
 	n.Handle("topology", s.topologyHandler)
+	n.Handle("sync", s.syncHandler)
+	n.Handle("sync_push", s.syncPushHandler)
+
+	go func() {
+		for {
+			select {
+			case <-time.After(antiEntropyFrequency):
+				s.antiEntropy()
+			}
+		}
+	}()
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -45,9 +66,21 @@ type server struct {
 
 	idsMu sync.RWMutex
 	ids   map[int]struct{}
+	// Append-only arrival log of the ids, used by the incremental reads
+	arrivals []int
+	// Values that aren't their own ID
+	values map[int]any
+
+	strategy topology.Strategy
+	nodesMu  sync.RWMutex
+	graph    topology.Graph
+
+	downMu sync.RWMutex
+	down   map[string]struct{}
+
+	syncPeer int
 
-	nodesMu sync.RWMutex
-	tree    *btree.Tree
+	quorum quorum
 }
 
 func (s *server) initHandler(_ maelstrom.Message) error {
@@ -57,7 +90,7 @@ func (s *server) initHandler(_ maelstrom.Message) error {
 		return err
 	}
 	s.id = id
-	return nil
+	return s.quorum.check(len(s.n.NodeIDs()))
 }
 
 func (s *server) broadcastHandler(msg maelstrom.Message) error {
@@ -66,99 +99,188 @@ func (s *server) broadcastHandler(msg maelstrom.Message) error {
 		return err
 	}
 
+	id, err := payload.ID(body)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+	s.idsMu.Lock()
+	_, exists := s.ids[id]
+	if !exists {
+		s.ids[id] = struct{}{}
+		s.arrivals = append(s.arrivals, id)
+		if message := body["message"]; !payload.Inline(message, id) {
+			s.values[id] = message
+		}
+	}
+	s.idsMu.Unlock()
+
+	if s.quorum.size > 0 && !s.isNode(msg.Src) {
+		// A client may retry after a TemporarilyUnavailable error, so a value
+		// we already have is still replicated before being acknowledged
+		if !exists {
+			if err := s.broadcast(msg.Src, body); err != nil {
+				return err
+			}
+		}
+		if err := s.replicate(body); err != nil {
+			return err
+		}
+		return s.n.Reply(msg, map[string]any{
+			"type": "broadcast_ok",
+		})
+	}
+
+	// Without a quorum, or for a value sent by another node, acknowledged as
+	// soon as stored
 	go func() {
 		_ = s.n.Reply(msg, map[string]any{
 			"type": "broadcast_ok",
 		})
 	}()
 
-	id := int(body["message"].(float64))
-	s.idsMu.Lock()
-	if _, exists := s.ids[id]; exists {
-		s.idsMu.Unlock()
+	if exists {
 		return nil
 	}
-	s.ids[id] = struct{}{}
-	s.idsMu.Unlock()
-
 	return s.broadcast(msg.Src, body)
 }
 
 func (s *server) broadcast(src string, body map[string]any) error {
-	s.nodesMu.RLock()
-	n := s.tree.GetNode(s.id)
-	defer s.nodesMu.RUnlock()
-
-	var neighbors []string
+	forward := forwardBody(body, "", "")
+	if failover, ok := body["failover"].(string); ok {
+		forward = forwardBody(body, "via", failover)
+	}
 
-	if n.Parent != nil {
-		neighbors = append(neighbors, n.Parent.Entries[0].Value.(string))
+	for _, dst := range s.targets(src, body) {
+		dst := dst
+		go s.send(dst, forward)
 	}
+	return nil
+}
 
-	for _, children := range n.Children {
-		for _, entry := range children.Entries {
-			neighbors = append(neighbors, entry.Value.(string))
+// send delivers a message to dst. If dst is unresponsive, the message is
+// relayed through an alternate node right away while we keep retrying dst in
+// the background.
+func (s *server) send(dst string, body map[string]any) {
+	if !s.isDown(dst) {
+		if err := s.rpc(dst, body); err == nil {
+			return
 		}
 	}
 
-	for _, dst := range neighbors {
-		if dst == src || dst == s.nodeID {
+	s.failover(dst, body)
+
+	var err error
+	for i := 0; i < maxRetry; i++ {
+		if err = s.rpc(dst, body); err != nil {
+			// Sleep and retry
+			time.Sleep(time.Duration(i) * time.Second)
 			continue
 		}
-
-		dst := dst
-		go func() {
-			if err := s.rpc(dst, body); err != nil {
-				for i := 0; i < maxRetry; i++ {
-					if err := s.rpc(dst, body); err != nil {
-						// Sleep and retry
-						time.Sleep(time.Duration(i) * time.Second)
-						continue
-					}
-					return
-				}
-				log.Error(err)
-			}
-		}()
+		return
 	}
-	return nil
+	log.Error(err)
 }
 
 func (s *server) rpc(dst string, body map[string]any) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 	_, err := s.n.SyncRPC(ctx, dst, body)
-	return err
+	if err != nil {
+		s.markDown(dst)
+		return err
+	}
+	s.markUp(dst)
+	return nil
 }
 
+type readMsg struct {
+	Cursor *int `json:"cursor"`
+}
+
+// readHandler returns all the ids or, if a cursor is provided, only the ids
+// learned since this cursor along with a new cursor. A cursor is a position in
+// the arrival log of the node, so it's only valid for the node that returned
+// it.
 func (s *server) readHandler(msg maelstrom.Message) error {
-	ids := s.getAllIDs()
+	var body readMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
 
+	if body.Cursor == nil {
+		return s.n.Reply(msg, map[string]any{
+			"type":     "read_ok",
+			"messages": s.messages(s.getAllIDs()),
+		})
+	}
+
+	ids, cursor, err := s.getIDsSince(*body.Cursor)
+	if err != nil {
+		return err
+	}
 	return s.n.Reply(msg, map[string]any{
 		"type":     "read_ok",
-		"messages": ids,
+		"messages": s.messages(ids),
+		"cursor":   cursor,
 	})
 }
 
-func (s *server) getAllIDs() []int {
+// messages returns the values of ids.
+func (s *server) messages(ids []int) []any {
 	s.idsMu.RLock()
-	ids := make([]int, 0, len(s.ids))
-	for id := range s.ids {
-		ids = append(ids, id)
+	defer s.idsMu.RUnlock()
+
+	res := make([]any, len(ids))
+	for i, id := range ids {
+		if v, exists := s.values[id]; exists {
+			res[i] = v
+		} else {
+			res[i] = id
+		}
 	}
+	return res
+}
+
+func (s *server) getAllIDs() []int {
+	s.idsMu.RLock()
+	ids := make([]int, len(s.arrivals))
+	copy(ids, s.arrivals)
 	s.idsMu.RUnlock()
 
 	return ids
 }
 
+// getIDsSince returns the ids learned since a cursor, along with the new
+// cursor.
+func (s *server) getIDsSince(cursor int) ([]int, int, error) {
+	s.idsMu.RLock()
+	defer s.idsMu.RUnlock()
+
+	if cursor < 0 || cursor > len(s.arrivals) {
+		return nil, 0, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("invalid cursor: %d", cursor))
+	}
+	ids := make([]int, len(s.arrivals)-cursor)
+	copy(ids, s.arrivals[cursor:])
+	return ids, len(s.arrivals), nil
+}
+
+type topologyMsg struct {
+	Topology map[string][]string `json:"topology"`
+}
+
 func (s *server) topologyHandler(msg maelstrom.Message) error {
-	tree := btree.NewWithIntComparator(len(s.n.NodeIDs()))
-	for i := 0; i < len(s.n.NodeIDs()); i++ {
-		tree.Put(i, fmt.Sprintf("n%d", i))
+	var t topologyMsg
+	if err := json.Unmarshal(msg.Body, &t); err != nil {
+		return err
+	}
+
+	graph, err := s.strategy.Build(s.n.NodeIDs(), t.Topology)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.Crash, err.Error())
 	}
 
 	s.nodesMu.Lock()
-	s.tree = tree
+	s.graph = graph
 	s.nodesMu.Unlock()
 
 	return s.n.Reply(msg, map[string]any{
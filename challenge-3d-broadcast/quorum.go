@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// By default, broadcast_ok is sent before any replication happens, so an
+// acknowledged value is lost if the node that received it crashes. With
+// BROADCAST_ACK_QUORUM=k, broadcast_ok is only sent to a client once k other
+// nodes acknowledged storing the value:
+//   - On top of the regular dissemination, the value is sent directly to k
+//     nodes (the neighbors first); a node that fails to answer is replaced by
+//     the next one, and if the acknowledgements are slow, another node is tried
+//     every quarter of the timeout
+//   - A node only acknowledges a broadcast once the value is stored
+//   - If the quorum isn't reached within BROADCAST_ACK_TIMEOUT (1s by default),
+//     the client gets a TemporarilyUnavailable error, even though the value may
+//     still be disseminated
+
+const (
+	defaultAckTimeout = time.Second
+	// The acknowledgements are hedged every quarter of the timeout
+	minAckTimeout = time.Millisecond
+)
+
+type quorum struct {
+	size    int
+	timeout time.Duration
+}
+
+func quorumFromEnv() (quorum, error) {
+	size, err := intFromEnv("BROADCAST_ACK_QUORUM", 0)
+	if err != nil {
+		return quorum{}, err
+	}
+	if size < 0 {
+		return quorum{}, fmt.Errorf("invalid BROADCAST_ACK_QUORUM: %d is negative", size)
+	}
+	timeout, err := durationFromEnv("BROADCAST_ACK_TIMEOUT", defaultAckTimeout)
+	if err != nil {
+		return quorum{}, err
+	}
+	if timeout < minAckTimeout {
+		return quorum{}, fmt.Errorf("invalid BROADCAST_ACK_TIMEOUT: %v is below %v", timeout, minAckTimeout)
+	}
+	return quorum{size: size, timeout: timeout}, nil
+}
+
+// check returns an error if the quorum can't be reached with the nodes of the
+// cluster, which is only known at init.
+func (q quorum) check(nodes int) error {
+	if q.size > nodes-1 {
+		return fmt.Errorf("BROADCAST_ACK_QUORUM %d exceeds the %d other nodes", q.size, nodes-1)
+	}
+	return nil
+}
+
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+func intFromEnv(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return i, nil
+}
+
+func (s *server) isNode(id string) bool {
+	for _, nodeID := range s.n.NodeIDs() {
+		if nodeID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// quorumPeers returns the nodes to replicate to: the neighbors first, then the
+// other nodes starting after this one (to spread the load), the unresponsive
+// ones last.
+func (s *server) quorumPeers() []string {
+	s.nodesMu.RLock()
+	neighbors := s.graph.Neighbors(s.nodeID)
+	s.nodesMu.RUnlock()
+
+	nodeIDs := s.n.NodeIDs()
+	for i, nodeID := range nodeIDs {
+		if nodeID == s.nodeID {
+			nodeIDs = append(append([]string(nil), nodeIDs[i+1:]...), nodeIDs[:i]...)
+			break
+		}
+	}
+
+	seen := map[string]bool{s.nodeID: true}
+	var peers, down []string
+	for _, nodeIDs := range [][]string{neighbors, nodeIDs} {
+		for _, nodeID := range nodeIDs {
+			if seen[nodeID] {
+				continue
+			}
+			seen[nodeID] = true
+			if s.isDown(nodeID) {
+				down = append(down, nodeID)
+				continue
+			}
+			peers = append(peers, nodeID)
+		}
+	}
+	return append(peers, down...)
+}
+
+// replicate sends a value received from a client directly to s.quorum.size
+// nodes and waits for their acknowledgements.
+func (s *server) replicate(body map[string]any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.quorum.timeout)
+	defer cancel()
+
+	peers := s.quorumPeers()
+	if len(peers) < s.quorum.size {
+		return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable,
+			fmt.Sprintf("a quorum of %d can't be reached with %d other nodes", s.quorum.size, len(peers)))
+	}
+
+	forward := forwardBody(body, "", "")
+	// Buffered so that the late replies don't block
+	results := make(chan error, len(peers))
+	next, inFlight, acks := 0, 0, 0
+	send := func() {
+		dst := peers[next]
+		next++
+		inFlight++
+		go func() {
+			results <- s.rpc(dst, forward)
+		}()
+	}
+	for next < len(peers) && inFlight < s.quorum.size {
+		send()
+	}
+	hedge := time.NewTicker(s.quorum.timeout / 4)
+	defer hedge.Stop()
+
+	for acks < s.quorum.size {
+		if inFlight == 0 {
+			break
+		}
+		select {
+		case err := <-results:
+			inFlight--
+			if err == nil {
+				acks++
+			} else if next < len(peers) {
+				send()
+			}
+		case <-hedge.C:
+			if next < len(peers) {
+				send()
+			}
+		case <-ctx.Done():
+			inFlight = 0
+		}
+	}
+	if acks < s.quorum.size {
+		return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable,
+			fmt.Sprintf("only %d out of %d nodes acknowledged the value", acks, s.quorum.size))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The batch policy decides when the outbox of a peer is flushed. It's selected
+// with the BATCH_POLICY environment variable:
+//   - fixed (default): all the outboxes are flushed every BATCH_INTERVAL
+//   - adaptive: the outboxes are checked every adaptiveTick and an outbox is
+//     flushed as soon as it holds BATCH_SIZE values or its oldest value waited
+//     for the current wait. Every second, the wait is tuned between
+//     minAdaptiveWait and BATCH_LATENCY_BUDGET (per hop) so that the estimated
+//     messages-per-operation converges to BATCH_TARGET_MSGS_PER_OP
+//
+// A node only sees its own traffic, so the estimate assumes all the nodes
+// behave alike: each flush is a broadcast and a broadcast_ok, and every node
+// learns every value, hence nodes * 2 * flushes / learned values.
+
+const (
+	fixedPolicy    = "fixed"
+	adaptivePolicy = "adaptive"
+
+	defaultBatchInterval   = 500 * time.Millisecond
+	defaultBatchSize       = 64
+	defaultLatencyBudget   = 400 * time.Millisecond
+	defaultTargetMsgsPerOp = 10
+
+	adaptiveTick    = 10 * time.Millisecond
+	minAdaptiveWait = 20 * time.Millisecond
+	tuneWindow      = time.Second
+	// The wait is multiplied or divided by this factor at each tuning
+	tuneFactor = 1.25
+)
+
+type batchPolicy interface {
+	// tick is the frequency at which the outboxes are checked.
+	tick() time.Duration
+	// due returns whether an outbox holding pending values, the oldest of
+	// them queued for age, has to be flushed.
+	due(pending int, age time.Duration) bool
+	// observe records that learned new values were received and that flushes
+	// broadcast RPCs were sent, in a cluster of nodes.
+	observe(learned, flushes, nodes int)
+}
+
+func batchPolicyFromEnv() (batchPolicy, error) {
+	interval, err := durationFromEnv("BATCH_INTERVAL", defaultBatchInterval)
+	if err != nil {
+		return nil, err
+	}
+	size, err := intFromEnv("BATCH_SIZE", defaultBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	budget, err := durationFromEnv("BATCH_LATENCY_BUDGET", defaultLatencyBudget)
+	if err != nil {
+		return nil, err
+	}
+	target, err := intFromEnv("BATCH_TARGET_MSGS_PER_OP", defaultTargetMsgsPerOp)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case interval <= 0:
+		return nil, fmt.Errorf("BATCH_INTERVAL must be positive, got %v", interval)
+	case size <= 0:
+		return nil, fmt.Errorf("BATCH_SIZE must be positive, got %d", size)
+	case target <= 0:
+		return nil, fmt.Errorf("BATCH_TARGET_MSGS_PER_OP must be positive, got %d", target)
+	case budget < minAdaptiveWait:
+		// The wait is tuned between minAdaptiveWait and the budget
+		return nil, fmt.Errorf("BATCH_LATENCY_BUDGET must be at least %v, got %v", minAdaptiveWait, budget)
+	}
+
+	switch name := os.Getenv("BATCH_POLICY"); name {
+	case fixedPolicy, "":
+		return fixedBatchPolicy{interval: interval}, nil
+	case adaptivePolicy:
+		return &adaptiveBatchPolicy{
+			size:        size,
+			budget:      budget,
+			target:      float64(target),
+			wait:        budget,
+			windowStart: time.Now(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown batch policy: %q", name)
+	}
+}
+
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+func intFromEnv(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return i, nil
+}
+
+type fixedBatchPolicy struct {
+	interval time.Duration
+}
+
+func (p fixedBatchPolicy) tick() time.Duration {
+	return p.interval
+}
+
+func (fixedBatchPolicy) due(int, time.Duration) bool {
+	return true
+}
+
+func (fixedBatchPolicy) observe(int, int, int) {}
+
+// adaptiveBatchPolicy is only used from the batch goroutine.
+type adaptiveBatchPolicy struct {
+	size   int
+	budget time.Duration
+	target float64
+
+	// Current wait
+	wait time.Duration
+
+	windowStart time.Time
+	learned     int
+	flushes     int
+}
+
+func (p *adaptiveBatchPolicy) tick() time.Duration {
+	return adaptiveTick
+}
+
+func (p *adaptiveBatchPolicy) due(pending int, age time.Duration) bool {
+	return pending >= p.size || age >= p.wait
+}
+
+func (p *adaptiveBatchPolicy) observe(learned, flushes, nodes int) {
+	p.learned += learned
+	p.flushes += flushes
+	if time.Since(p.windowStart) < tuneWindow {
+		return
+	}
+
+	// Without new values, there's nothing to estimate
+	if p.learned != 0 && nodes != 0 {
+		msgsPerOp := float64(nodes*2*p.flushes) / float64(p.learned)
+		switch {
+		case msgsPerOp > p.target:
+			p.wait = time.Duration(float64(p.wait) * tuneFactor)
+		case msgsPerOp < p.target/tuneFactor:
+			p.wait = time.Duration(float64(p.wait) / tuneFactor)
+		}
+		if p.wait > p.budget {
+			p.wait = p.budget
+		}
+		if p.wait < minAdaptiveWait {
+			p.wait = minAdaptiveWait
+		}
+	}
+
+	p.windowStart = time.Now()
+	p.learned = 0
+	p.flushes = 0
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// In causal mode (BROADCAST_MODE=causal), the values are disseminated like in
+// batch mode, but a node only delivers a value (i.e., stores it and exposes it
+// to the reads) once all the values it causally depends on were delivered:
+//   - Each node keeps a vector clock of the number of values delivered per
+//     origin node
+//   - A value received from a client is delivered right away and tagged with
+//     its origin and the vector clock of the origin once delivered
+//   - A value from origin o with clock C is delivered once C[o] = D[o] + 1 and
+//     C[j] <= D[j] for all the other nodes j, where D is the local vector
+//     clock. Until then, it's buffered
+//
+// The clocks are attached to every message carrying values (batches and
+// anti-entropy) in a clocks field. A value is only forwarded once delivered, so
+// the arrival log lists the values in a causally consistent order, which is
+// what read_causal returns.
+//
+// The same value can be broadcast by clients to several nodes, so a value can
+// have multiple tags (an origin and a clock). The tags are deduplicated by
+// origin and sequence number (the clock entry of the origin), and they are all
+// delivered and forwarded: otherwise, the entry of an origin would stop
+// progressing and all its later values would stay buffered. The value itself is
+// stored when its first tag is delivered; read_causal returns all the tags of
+// each value, the first one being the clock the value was exposed with. For the
+// anti-entropy to repair a missing tag, a value counts once per tag in
+// the digests.
+
+type vclock map[string]int
+
+type causalValue struct {
+	Origin string `json:"origin"`
+	Clock  vclock `json:"clock"`
+}
+
+// seq returns the sequence number of a value at its origin.
+func (v causalValue) seq() int {
+	return v.Clock[v.Origin]
+}
+
+type causalMsg struct {
+	Clocks map[int][]causalValue `json:"clocks"`
+}
+
+// tagKey identifies a tag.
+type tagKey struct {
+	origin string
+	seq    int
+}
+
+type causal struct {
+	s *server
+
+	mu        sync.Mutex
+	delivered vclock
+	// Delivered tags of the values, in delivery order
+	tags map[int][]causalValue
+	// Tags whose dependencies weren't delivered yet
+	buffer map[tagKey]buffered
+}
+
+type buffered struct {
+	// Node the tag was received from
+	src     string
+	message int
+	value   causalValue
+}
+
+// delivery is a value delivered after being received from src.
+type delivery struct {
+	src     string
+	message int
+}
+
+func newCausal(s *server) *causal {
+	return &causal{
+		s:         s,
+		delivered: make(vclock),
+		tags:      make(map[int][]causalValue),
+		buffer:    make(map[tagKey]buffered),
+	}
+}
+
+// local delivers a value received from a client. It returns false if the value
+// was already delivered.
+func (c *causal) local(message int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.tags[message]; exists {
+		return false
+	}
+	c.delivered[c.s.nodeID]++
+	value := causalValue{
+		Origin: c.s.nodeID,
+		Clock:  c.delivered.copy(),
+	}
+	c.tags[message] = append(c.tags[message], value)
+	c.s.addID(message)
+	return true
+}
+
+// receive buffers the tags received from src and returns the values whose tags
+// became deliverable, in delivery order. A value already stored is returned
+// again when one of its other tags is delivered, so that the tag is forwarded.
+// The delivered values are stored while holding the lock so that the arrival
+// log follows the delivery order.
+func (c *causal) receive(src string, values map[int][]causalValue) []delivery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for message, tags := range values {
+		for _, value := range tags {
+			key := tagKey{origin: value.Origin, seq: value.seq()}
+			if key.seq <= c.delivered[key.origin] {
+				continue
+			}
+			if _, exists := c.buffer[key]; exists {
+				continue
+			}
+			c.buffer[key] = buffered{src: src, message: message, value: value}
+		}
+	}
+
+	var res []delivery
+	for progress := true; progress; {
+		progress = false
+		for key, b := range c.buffer {
+			if !c.deliverable(b.value) {
+				continue
+			}
+			delete(c.buffer, key)
+			c.delivered[key.origin] = key.seq
+			if _, exists := c.tags[b.message]; !exists {
+				c.s.addID(b.message)
+			}
+			c.tags[b.message] = append(c.tags[b.message], b.value)
+			res = append(res, delivery{src: b.src, message: b.message})
+			progress = true
+		}
+	}
+	return res
+}
+
+func (c *causal) deliverable(value causalValue) bool {
+	for node, n := range value.Clock {
+		if node == value.Origin {
+			if n != c.delivered[node]+1 {
+				return false
+			}
+			continue
+		}
+		if n > c.delivered[node] {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsOf returns the delivered tags of values.
+func (c *causal) tagsOf(messages []int) map[int][]causalValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make(map[int][]causalValue, len(messages))
+	for _, message := range messages {
+		if tags, exists := c.tags[message]; exists {
+			res[message] = tags
+		}
+	}
+	return res
+}
+
+// tagCounts returns the number of delivered tags of each value.
+func (c *causal) tagCounts() map[int]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make(map[int]int, len(c.tags))
+	for message, tags := range c.tags {
+		res[message] = len(tags)
+	}
+	return res
+}
+
+func (v vclock) copy() vclock {
+	res := make(vclock, len(v))
+	for node, n := range v {
+		res[node] = n
+	}
+	return res
+}
+
+// withClocks attaches the tags of the values to a body in causal mode.
+func (s *server) withClocks(body map[string]any, messages []int) map[string]any {
+	if s.causal != nil {
+		body["clocks"] = s.causal.tagsOf(messages)
+	}
+	return body
+}
+
+// causalBroadcast forwards the delivered values, each of them to the targets
+// of the node it was received from.
+func (s *server) causalBroadcast(body map[string]any, deliveries []delivery) error {
+	bySrc := make(map[string][]int)
+	var srcs []string
+	for _, d := range deliveries {
+		if _, exists := bySrc[d.src]; !exists {
+			srcs = append(srcs, d.src)
+		}
+		bySrc[d.src] = append(bySrc[d.src], d.message)
+	}
+
+	for _, src := range srcs {
+		if err := s.batchBroadcast(src, body, bySrc[src]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) causalBatchHandler(msg maelstrom.Message, body map[string]any) error {
+	var values causalMsg
+	if err := json.Unmarshal(msg.Body, &values); err != nil {
+		return err
+	}
+	return s.causalBroadcast(body, s.causal.receive(msg.Src, values.Clocks))
+}
+
+// readCausalHandler returns the delivered values in delivery order, along with
+// their tags. The tags are indexed by ID, so if some values aren't
+// integers, the IDs are returned as well.
+func (s *server) readCausalHandler(msg maelstrom.Message) error {
+	if s.causal == nil {
+		return maelstrom.NewRPCError(maelstrom.NotSupported, "read_causal requires BROADCAST_MODE=causal")
+	}
+
+	ids := s.getAllIDs()
+	res := map[string]any{
+		"type":     "read_causal_ok",
+		"messages": s.messages(ids),
+		"clocks":   s.causal.tagsOf(ids),
+	}
+	if !s.inline(ids) {
+		res["ids"] = ids
+	}
+	return s.n.Reply(msg, res)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestCausal runs the causal mode under a partition and checks that, on every
+// node, read_causal lists each value after all its causal dependencies.
+func TestCausal(t *testing.T) {
+	o := scenario{
+		env:       []string{"BROADCAST_MODE=causal"},
+		nodes:     9,
+		ops:       500,
+		rate:      100,
+		latency:   50 * time.Millisecond,
+		partition: 3 * time.Second,
+		rounds:    1,
+		settle:    8 * time.Second,
+		dup:       0.2,
+	}.run(t)
+
+	for _, id := range o.ids {
+		res, err := o.cluster.RPC(id, map[string]any{"type": "read_causal"}, 5*time.Second)
+		if err != nil {
+			t.Errorf("%s: read_causal: %v", id, err)
+			continue
+		}
+		if violations := checkCausal(res); violations > 0 {
+			t.Errorf("%s: %d values read before their dependencies", id, violations)
+		}
+	}
+}
+
+// checkCausal returns the number of dependencies of the values listed by
+// read_causal that are listed after them (or not at all). A value is exposed
+// with its first tag, so its dependencies are those of the first tag, and a
+// dependency is listed with the value carrying it, whichever its tag.
+func checkCausal(res map[string]any) int {
+	var r struct {
+		Messages []json.RawMessage        `json:"messages"`
+		IDs      []int                    `json:"ids"`
+		Clocks   map[string][]causalValue `json:"clocks"`
+	}
+	b, _ := json.Marshal(res)
+	if err := json.Unmarshal(b, &r); err != nil {
+		return 1
+	}
+	keys := make([]string, len(r.Messages))
+	for i, m := range r.Messages {
+		keys[i] = string(m)
+	}
+	if r.IDs != nil {
+		for i, id := range r.IDs {
+			keys[i] = fmt.Sprint(id)
+		}
+	}
+
+	type tag struct {
+		origin string
+		seq    int
+	}
+	position := make(map[tag]int)
+	for i, key := range keys {
+		for _, v := range r.Clocks[key] {
+			position[tag{v.Origin, v.seq()}] = i
+		}
+	}
+
+	violations := 0
+	for i, key := range keys {
+		if len(r.Clocks[key]) == 0 {
+			violations++
+			continue
+		}
+		v := r.Clocks[key][0]
+		for origin, n := range v.Clock {
+			if origin == v.Origin {
+				// The value itself
+				n--
+			}
+			for seq := 1; seq <= n; seq++ {
+				if p, exists := position[tag{origin, seq}]; !exists || p > i {
+					violations++
+				}
+			}
+		}
+	}
+	return violations
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teivah/gossip-glomers/harness"
+)
+
+var seed = flag.Int64("seed", 0, "random seed of the cluster tests, random if 0")
+
+// bin is the node binary, built once for all the tests.
+var bin string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	dir, err := os.MkdirTemp("", "broadcast")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	bin = filepath.Join(dir, "bin")
+	if err := harness.Build(bin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// scenario describes a run: the values are broadcast at a given rate, with
+// network partitions splitting the nodes in two random halves at the
+// beginning, then the partitions are healed and the cluster settles. Some
+// values are broadcast to two nodes, so that they carry several tags.
+type scenario struct {
+	env       []string
+	nodes     int
+	ops       int
+	rate      float64
+	latency   time.Duration
+	partition time.Duration
+	// Number of partitions, each splitting the nodes differently
+	rounds int
+	settle time.Duration
+	// Fraction of the values broadcast to two nodes
+	dup float64
+	// Reads made during the run, every 10 broadcasts
+	readDuring bool
+}
+
+// outcome is the result of a run.
+type outcome struct {
+	cluster *harness.Cluster
+	ids     []string
+	acked   []int
+	// Reads made during the run and once the cluster settled
+	reads [][]int
+}
+
+// run runs a scenario and checks that every acknowledged value is read by every
+// node once the cluster settled. The cluster is closed at the end of the test.
+func (sc scenario) run(t *testing.T) outcome {
+	if testing.Short() {
+		t.Skip("cluster test")
+	}
+	s := *seed
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", s)
+	rng := rand.New(rand.NewSource(s))
+
+	c := harness.NewCluster(sc.latency)
+	t.Cleanup(c.Close)
+	o := outcome{cluster: c}
+	for i := 0; i < sc.nodes; i++ {
+		o.ids = append(o.ids, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range o.ids {
+		if err := c.Start(bin, id, sc.env...); err != nil {
+			t.Fatalf("starting %s: %v", id, err)
+		}
+	}
+	topology := make(map[string][]string)
+	for i, id := range o.ids {
+		topology[id] = []string{o.ids[(i+1)%len(o.ids)], o.ids[(i+len(o.ids)-1)%len(o.ids)]}
+	}
+	if err := c.Init(o.ids, topology); err != nil {
+		t.Fatal(err)
+	}
+
+	// Broadcast the values, changing the partition every round until it heals
+	start := time.Now()
+	round := -1
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for i := 0; i < sc.ops; i++ {
+		if elapsed := time.Since(start); elapsed < sc.partition {
+			if r := int(elapsed * time.Duration(sc.rounds) / sc.partition); r != round {
+				round = r
+				c.Split(rng, o.ids)
+			}
+		} else {
+			c.Heal()
+		}
+		if sc.readDuring && i%10 == 0 {
+			wg.Add(1)
+			go func(dst string) {
+				defer wg.Done()
+				res, err := c.RPC(dst, map[string]any{"type": "read"}, 5*time.Second)
+				if err == nil {
+					mu.Lock()
+					o.reads = append(o.reads, ints(res["messages"]))
+					mu.Unlock()
+				}
+			}(o.ids[rng.Intn(len(o.ids))])
+		}
+		targets := []string{o.ids[rng.Intn(len(o.ids))]}
+		if rng.Float64() < sc.dup {
+			targets = append(targets, o.ids[rng.Intn(len(o.ids))])
+		}
+		for _, dst := range targets {
+			wg.Add(1)
+			go func(dst string, value int) {
+				defer wg.Done()
+				res, err := c.RPC(dst, map[string]any{"type": "broadcast", "message": value}, 5*time.Second)
+				if err == nil && res["type"] == "broadcast_ok" {
+					mu.Lock()
+					o.acked = append(o.acked, value)
+					mu.Unlock()
+				}
+			}(dst, i)
+		}
+		time.Sleep(time.Duration(float64(time.Second) / sc.rate))
+	}
+	wg.Wait()
+	c.Heal()
+	time.Sleep(sc.settle)
+
+	for _, id := range o.ids {
+		res, err := c.RPC(id, map[string]any{"type": "read"}, 5*time.Second)
+		if err != nil {
+			t.Errorf("%s: read: %v", id, err)
+			continue
+		}
+		read := ints(res["messages"])
+		o.reads = append(o.reads, read)
+		if missing := difference(o.acked, read); len(missing) > 0 {
+			t.Errorf("%s: %d acknowledged values missing, e.g. %v", id, len(missing), missing[:min(5, len(missing))])
+		}
+	}
+	t.Logf("%d values acknowledged, %d messages dropped by the partitions", len(unique(o.acked)), c.Dropped())
+	return o
+}
+
+func ints(v any) []int {
+	values, _ := v.([]any)
+	res := make([]int, 0, len(values))
+	for _, value := range values {
+		if f, ok := value.(float64); ok {
+			res = append(res, int(f))
+		}
+	}
+	return res
+}
+
+func unique(values []int) []int {
+	set := make(map[int]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	res := make([]int, 0, len(set))
+	for v := range set {
+		res = append(res, v)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// difference returns the values of a that aren't in b.
+func difference(a, b []int) []int {
+	set := make(map[int]struct{}, len(b))
+	for _, v := range b {
+		set[v] = struct{}{}
+	}
+	var res []int
+	for _, v := range unique(a) {
+		if _, exists := set[v]; !exists {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
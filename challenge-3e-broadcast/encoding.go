@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// As the broadcast values are mostly consecutive integers, a batch can be
+// encoded as a list of inclusive ranges: [1, 2, 3, 8, 72] becomes
+// [[1, 3], [8, 8], [72, 72]].
+//
+// The encoding is negotiated so that a peer only accepting plain arrays keeps
+// working: a node replies to a batch with the encodings it supports, and a
+// batch is sent as ranges (the ranges field instead of messages) only once the
+// peer announced it supports them. The first batch to a peer is always a plain
+// array.
+//
+// A client can also ask for a compressed read by sending "encoding": "ranges",
+// as long as all the values are integers.
+
+const (
+	rangesEncoding = "ranges"
+	// Far more values than a batch holds
+	maxDecodedIDs = 1 << 20
+)
+
+var supportedEncodings = []string{rangesEncoding}
+
+type batchMsg struct {
+	Messages []int    `json:"messages"`
+	Ranges   [][2]int `json:"ranges"`
+}
+
+type encodingsMsg struct {
+	Encodings []string `json:"encodings"`
+}
+
+// encodeRanges returns the sorted ranges of values.
+func encodeRanges(values []int) [][2]int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+
+	var ranges [][2]int
+	for _, v := range sorted {
+		if len(ranges) != 0 {
+			last := &ranges[len(ranges)-1]
+			if v <= last[1]+1 {
+				if v > last[1] {
+					last[1] = v
+				}
+				continue
+			}
+		}
+		ranges = append(ranges, [2]int{v, v})
+	}
+	return ranges
+}
+
+// decodeRanges returns the values of ranges. As the ranges come from the
+// network, a reversed range is rejected, and so is a batch expanding to more
+// than maxDecodedIDs values.
+func decodeRanges(ranges [][2]int) ([]int, error) {
+	total := uint64(0)
+	for _, r := range ranges {
+		if r[1] < r[0] {
+			return nil, fmt.Errorf("invalid range [%d, %d]", r[0], r[1])
+		}
+		// Computed on unsigned integers so that it can't overflow
+		span := uint64(r[1]) - uint64(r[0])
+		if span >= maxDecodedIDs-total {
+			return nil, fmt.Errorf("ranges expanding to more than %d values", maxDecodedIDs)
+		}
+		total += span + 1
+	}
+
+	values := make([]int, 0, total)
+	for _, r := range ranges {
+		// Counting down rather than comparing v to r[1], which may be the
+		// largest int
+		for n := r[1] - r[0]; n >= 0; n-- {
+			values = append(values, r[1]-n)
+		}
+	}
+	return values, nil
+}
+
+// decodeBatch returns the values of a batch, whatever its encoding.
+func (s *server) decodeBatch(src string, data json.RawMessage) ([]int, error) {
+	var body batchMsg
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	if body.Ranges == nil {
+		return body.Messages, nil
+	}
+	// The sender obviously supports ranges
+	s.setRanges(src)
+	return decodeRanges(body.Ranges)
+}
+
+// batch returns a batch body for dst.
+func (s *server) batch(dst string, messages []int, fields map[string]any) map[string]any {
+	body := map[string]any{
+		"type": "broadcast",
+	}
+	for k, v := range fields {
+		body[k] = v
+	}
+
+	s.encodingsMu.RLock()
+	ranges := s.rangePeers[dst]
+	s.encodingsMu.RUnlock()
+	if ranges && s.inline(messages) {
+		body["ranges"] = encodeRanges(messages)
+	} else {
+		body["messages"] = messages
+	}
+	return s.withHighWater(s.withClocks(s.withPayloads(body, messages), messages), messages)
+}
+
+// learnEncodings records the encodings announced in a reply from dst.
+func (s *server) learnEncodings(dst string, data json.RawMessage) {
+	var body encodingsMsg
+	if err := json.Unmarshal(data, &body); err != nil {
+		return
+	}
+	for _, encoding := range body.Encodings {
+		if encoding == rangesEncoding {
+			s.setRanges(dst)
+		}
+	}
+}
+
+func (s *server) setRanges(nodeID string) {
+	s.encodingsMu.RLock()
+	known := s.rangePeers[nodeID]
+	s.encodingsMu.RUnlock()
+	if known {
+		return
+	}
+
+	s.encodingsMu.Lock()
+	s.rangePeers[nodeID] = true
+	s.encodingsMu.Unlock()
+}
@@ -0,0 +1,128 @@
+package main
+
+import log "github.com/sirupsen/logrus"
+
+// With the flat tree, if the root is unreachable, none of the children receive
+// any broadcast message. When a node fails to reach one of its neighbors, it
+// marks it as down and relays the message through an alternate node: the first
+// healthy neighbor of the unresponsive node (for the flat tree, the first
+// healthy child, which acts as an alternate root). The alternate broadcasts the
+// message on behalf of the unresponsive node. As the neighbors are sorted, all
+// the nodes sharing the same view pick the same alternate.
+//
+// Two fields are added to the broadcast messages:
+//   - failover: the unresponsive node the receiver has to relay on behalf of
+//   - via: the unresponsive node the message was relayed on behalf of, so that
+//     the receiver doesn't try to send it back to it
+
+func (s *server) markDown(nodeID string) {
+	s.downMu.Lock()
+	s.down[nodeID] = struct{}{}
+	s.downMu.Unlock()
+}
+
+func (s *server) markUp(nodeID string) {
+	s.downMu.Lock()
+	delete(s.down, nodeID)
+	s.downMu.Unlock()
+}
+
+// isDown returns whether a node failed to answer our last RPC or is suspected
+// by the membership.
+func (s *server) isDown(nodeID string) bool {
+	if !s.members.IsLive(nodeID) {
+		return true
+	}
+
+	s.downMu.RLock()
+	_, exists := s.down[nodeID]
+	s.downMu.RUnlock()
+	return exists
+}
+
+// targets returns the nodes a message received from src has to be sent to.
+func (s *server) targets(src string, body map[string]any) []string {
+	s.nodesMu.RLock()
+	neighbors := append([]string(nil), s.graph.Neighbors(s.nodeID)...)
+	skip := map[string]bool{src: true, s.nodeID: true}
+	if failover, ok := body["failover"].(string); ok {
+		// We are the alternate of an unresponsive node
+		neighbors = append(neighbors, s.graph.Neighbors(failover)...)
+		skip[failover] = true
+	}
+	s.nodesMu.RUnlock()
+
+	if via, ok := body["via"].(string); ok {
+		skip[via] = true
+	}
+
+	var targets []string
+	for _, dst := range neighbors {
+		if skip[dst] {
+			continue
+		}
+		skip[dst] = true
+		targets = append(targets, dst)
+	}
+	return targets
+}
+
+// alternate returns the first healthy neighbor of an unresponsive node.
+func (s *server) alternate(nodeID string) (string, bool) {
+	s.nodesMu.RLock()
+	neighbors := s.graph.Neighbors(nodeID)
+	s.nodesMu.RUnlock()
+
+	for _, neighbor := range neighbors {
+		if !s.isDown(neighbor) {
+			return neighbor, true
+		}
+	}
+	return "", false
+}
+
+// failover relays values that couldn't be delivered to dst.
+func (s *server) failover(dst string, messages []int) {
+	if len(messages) == 0 {
+		return
+	}
+	alternate, exists := s.alternate(dst)
+	if !exists {
+		return
+	}
+
+	if alternate != s.nodeID {
+		go func() {
+			if err := s.rpc(alternate, s.batch(alternate, messages, map[string]any{
+				"failover": dst,
+			})); err != nil {
+				// The alternate is now marked as down, so the next one is tried
+				s.failover(dst, messages)
+			}
+		}()
+		return
+	}
+
+	// We are the alternate, so we broadcast directly to the neighbors of dst
+	s.nodesMu.RLock()
+	neighbors := s.graph.Neighbors(dst)
+	s.nodesMu.RUnlock()
+	for _, neighbor := range neighbors {
+		if neighbor == s.nodeID || s.isDown(neighbor) {
+			continue
+		}
+		neighbor := neighbor
+		go s.relay(neighbor, messages, dst)
+	}
+}
+
+// relay sends values on behalf of an unresponsive node. It's best effort: the
+// node that couldn't reach the unresponsive one keeps the values pending until
+// it's back.
+func (s *server) relay(dst string, messages []int, via string) {
+	if err := s.rpc(dst, s.batch(dst, messages, map[string]any{
+		"via": via,
+	})); err != nil {
+		log.Warnf("failed to relay messages to %s on behalf of %s: %v", dst, via, err)
+	}
+}
@@ -3,9 +3,22 @@ module github.com/teivah/gossip-glomers/challenge-3e-broadcast
 go 1.20
 
 require (
-	github.com/emirpasic/gods v1.18.1
 	github.com/jepsen-io/maelstrom/demo/go v0.0.0-20230113211434-22f433519054
 	github.com/sirupsen/logrus v1.9.0
+	github.com/teivah/gossip-glomers/harness v0.0.0
+	github.com/teivah/gossip-glomers/membership v0.0.0
+	github.com/teivah/gossip-glomers/payload v0.0.0
+	github.com/teivah/gossip-glomers/topology v0.0.0
 )
 
-require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+require (
+	github.com/emirpasic/gods v1.18.1 // indirect
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+)
+
+replace (
+	github.com/teivah/gossip-glomers/harness => ../harness
+	github.com/teivah/gossip-glomers/membership => ../membership
+	github.com/teivah/gossip-glomers/payload => ../payload
+	github.com/teivah/gossip-glomers/topology => ../topology
+)
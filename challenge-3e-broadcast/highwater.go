@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// In batch mode, a node forwards a value to all its neighbors but
+// the one it was received from, even though, in a dense topology, most of them
+// already received it through another path. To avoid it, each node keeps track
+// of what its peers have:
+//   - A value received from a client is tagged with the node (its origin) and a
+//     per-origin sequence number
+//   - Each node maintains its high-water marks: for each origin, the highest
+//     sequence number up to which it stored all the values (a version vector)
+//   - The tags and the marks are attached to the batches and to the
+//     anti-entropy messages, and the marks to the replies to the batches, so
+//     each node learns the marks of its peers
+//   - Before flushing an outbox, the values covered by the marks of the peer
+//     are acknowledged without being sent
+//
+// The same value can be broadcast by clients to several nodes, so a value can
+// have multiple tags. They are all kept, otherwise the marks of an origin
+// would stop progressing.
+
+type tag struct {
+	Origin string `json:"origin"`
+	Seq    int    `json:"seq"`
+}
+
+type highWaterMsg struct {
+	Tags      map[int][]tag `json:"tags"`
+	HighWater vclock        `json:"high_water"`
+}
+
+type highWater struct {
+	s *server
+
+	mu  sync.Mutex
+	seq int
+	// Tags of the stored values
+	tags map[int][]tag
+	// Local high-water marks
+	marks vclock
+	// Sequence numbers stored above the local marks
+	above map[string]map[int]bool
+	// Marks announced by each peer
+	peers map[string]vclock
+}
+
+func newHighWater(s *server) *highWater {
+	return &highWater{
+		s:     s,
+		tags:  make(map[int][]tag),
+		marks: make(vclock),
+		above: make(map[string]map[int]bool),
+		peers: make(map[string]vclock),
+	}
+}
+
+// local tags a value received from a client.
+func (h *highWater) local(message int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	h.add(message, tag{Origin: h.s.nodeID, Seq: h.seq})
+}
+
+// receive records the tags and the marks attached to a message from src.
+func (h *highWater) receive(src string, data json.RawMessage) {
+	var body highWaterMsg
+	if err := json.Unmarshal(data, &body); err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for message, tags := range body.Tags {
+		for _, t := range tags {
+			h.add(message, t)
+		}
+	}
+	if len(body.HighWater) == 0 {
+		return
+	}
+	marks, exists := h.peers[src]
+	if !exists {
+		marks = make(vclock)
+		h.peers[src] = marks
+	}
+	for origin, seq := range body.HighWater {
+		if seq > marks[origin] {
+			marks[origin] = seq
+		}
+	}
+}
+
+// add records a tag. It must be called while holding the lock.
+func (h *highWater) add(message int, t tag) {
+	if t.Seq <= h.marks[t.Origin] || h.above[t.Origin][t.Seq] {
+		return
+	}
+	h.tags[message] = append(h.tags[message], t)
+
+	above, exists := h.above[t.Origin]
+	if !exists {
+		above = make(map[int]bool)
+		h.above[t.Origin] = above
+	}
+	above[t.Seq] = true
+	for above[h.marks[t.Origin]+1] {
+		h.marks[t.Origin]++
+		delete(above, h.marks[t.Origin])
+	}
+}
+
+// uncovered splits values between the ones dst may not have and the ones
+// covered by its marks.
+func (h *highWater) uncovered(dst string, messages []int) ([]int, []int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	marks := h.peers[dst]
+	if len(marks) == 0 {
+		return messages, nil
+	}
+	var uncovered, covered []int
+	for _, message := range messages {
+		if h.covered(marks, message) {
+			covered = append(covered, message)
+		} else {
+			uncovered = append(uncovered, message)
+		}
+	}
+	return uncovered, covered
+}
+
+// covered returns whether one of the tags of a value is covered by marks. It
+// must be called while holding the lock.
+func (h *highWater) covered(marks vclock, message int) bool {
+	for _, t := range h.tags[message] {
+		if t.Seq <= marks[t.Origin] {
+			return true
+		}
+	}
+	return false
+}
+
+// learnHighWater records the tags and the marks attached to a message from
+// src.
+func (s *server) learnHighWater(src string, data json.RawMessage) {
+	if s.highWater != nil {
+		s.highWater.receive(src, data)
+	}
+}
+
+// withHighWater attaches the tags of the values and the local marks to a body.
+func (s *server) withHighWater(body map[string]any, messages []int) map[string]any {
+	h := s.highWater
+	if h == nil {
+		return body
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(messages) != 0 {
+		tags := make(map[int][]tag, len(messages))
+		for _, message := range messages {
+			if t, exists := h.tags[message]; exists {
+				tags[message] = t
+			}
+		}
+		body["tags"] = tags
+	}
+	body["high_water"] = h.marks.copy()
+	return body
+}
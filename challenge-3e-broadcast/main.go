@@ -7,16 +7,21 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/emirpasic/gods/trees/btree"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/membership"
+	"github.com/teivah/gossip-glomers/topology"
 )
 
+// Broadcast modes, selected with the BROADCAST_MODE environment variable.
 const (
-	batchFrequency = 500 * time.Millisecond
-	maxRetry       = 100
+	batchMode    = "batch"
+	plumtreeMode = "plumtree"
+	causalMode   = "causal"
+	totalMode    = "total-order"
 )
 
 func init() {
@@ -28,22 +33,102 @@ func init() {
 }
 
 func main() {
+	strategy, err := topology.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	policy, err := batchPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	q, err := quorumFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	n := maelstrom.NewNode()
-	s := &server{n: n, ids: make(map[int]struct{}), broadcasts: make(map[string][]int)}
+	members := membership.New(n, membership.ConfigFromEnv())
+	s := &server{
+		n:          n,
+		ids:        make(map[int]struct{}),
+		values:     make(map[int]any),
+		outbox:     newOutbox(),
+		rangePeers: make(map[string]bool),
+		policy:     policy,
+		quorum:     q,
+		strategy:   strategy,
+		down:       make(map[string]struct{}),
+		members:    members,
+	}
 
 	n.Handle("init", s.initHandler)
-	n.Handle("broadcast", s.broadcastHandler)
+	n.Handle("broadcast", members.Wrap(s.broadcastHandler))
 	n.Handle("read", s.readHandler)
 	n.Handle("topology", s.topologyHandler)
+	n.Handle("sync", members.Wrap(s.syncHandler))
+	n.Handle("sync_push", members.Wrap(s.syncPushHandler))
 
-	go func() {
-		for {
-			select {
-			case <-time.After(batchFrequency):
-				s.batchRPC()
-			}
+	n.Handle("read_causal", s.readCausalHandler)
+
+	mode := os.Getenv("BROADCAST_MODE")
+	if q.size > 0 && (mode == causalMode || mode == totalMode) {
+		log.Fatalf("BROADCAST_ACK_QUORUM isn't supported in %s mode", mode)
+	}
+
+	switch mode {
+	case batchMode, causalMode, "":
+		if mode == causalMode {
+			s.causal = newCausal(s)
+		} else {
+			// A value covered by the marks of a peer may still carry a causal
+			// tag the peer lacks, so the marks are only used in batch mode
+			s.highWater = newHighWater(s)
 		}
-	}()
+		go func() {
+			for {
+				select {
+				case <-time.After(policy.tick()):
+					s.batchRPC()
+				}
+			}
+		}()
+	case totalMode:
+		s.total = newTotalOrder(s)
+		s.total.handle(n)
+		go func() {
+			for {
+				select {
+				case <-time.After(orderFrequency):
+					s.total.tick()
+				}
+			}
+		}()
+	case plumtreeMode:
+		s.plumtree = newPlumtree(s)
+		s.plumtree.handle(n, members)
+		go func() {
+			for {
+				select {
+				case <-time.After(ihaveFrequency):
+					s.plumtree.flushIHaves()
+				}
+			}
+		}()
+	default:
+		log.Fatalf("unknown broadcast mode: %q", mode)
+	}
+
+	// The values are delivered in sequence order, so anti-entropy doesn't apply
+	if s.total == nil {
+		go func() {
+			for {
+				select {
+				case <-time.After(antiEntropyFrequency):
+					s.antiEntropy()
+				}
+			}
+		}()
+	}
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -57,12 +142,40 @@ type server struct {
 
 	idsMu sync.RWMutex
 	ids   map[int]struct{}
-
-	nodesMu sync.RWMutex
-	tree    *btree.Tree
-
-	broadcastsMu sync.Mutex
-	broadcasts   map[string][]int
+	// Append-only arrival log of the ids, used by the incremental reads
+	arrivals []int
+	// Values that aren't their own ID
+	values map[int]any
+
+	members  *membership.Membership
+	strategy topology.Strategy
+	nodesMu  sync.RWMutex
+	graph    topology.Graph
+
+	outbox *outbox
+	policy batchPolicy
+	// New values received since the last batch
+	learned atomic.Int64
+
+	encodingsMu sync.RWMutex
+	// Peers supporting the ranges encoding
+	rangePeers map[string]bool
+
+	downMu sync.RWMutex
+	down   map[string]struct{}
+
+	syncPeer int
+
+	quorum quorum
+
+	// Only set in plumtree mode
+	plumtree *plumtree
+	// Only set in causal mode
+	causal *causal
+	// Only set in total-order mode
+	total *totalOrder
+	// Only set in batch mode
+	highWater *highWater
 }
 
 func (s *server) initHandler(_ maelstrom.Message) error {
@@ -72,6 +185,10 @@ func (s *server) initHandler(_ maelstrom.Message) error {
 		return err
 	}
 	s.id = v
+	if err := s.quorum.check(len(s.n.NodeIDs())); err != nil {
+		return err
+	}
+	s.members.Start()
 	return nil
 }
 
@@ -81,173 +198,283 @@ func (s *server) broadcastHandler(msg maelstrom.Message) error {
 		return err
 	}
 
-	go func() {
-		_ = s.n.Reply(msg, map[string]any{
+	if _, contains := body["message"]; contains {
+		message, err := s.storeValue(body)
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+		if s.quorum.size == 0 {
+			go func() {
+				_ = s.n.Reply(msg, map[string]any{
+					"type": "broadcast_ok",
+				})
+			}()
+			return s.receive(msg.Src, body, message)
+		}
+
+		if err := s.receive(msg.Src, body, message); err != nil {
+			return err
+		}
+		if err := s.replicate(message); err != nil {
+			return err
+		}
+		return s.n.Reply(msg, map[string]any{
 			"type": "broadcast_ok",
 		})
-	}()
+	}
 
-	if _, contains := body["message"]; contains {
-		message := int(body["message"].(float64))
-		s.idsMu.Lock()
-		if _, exists := s.ids[message]; exists {
-			s.idsMu.Unlock()
-			return nil
-		}
-		s.ids[message] = struct{}{}
-		s.idsMu.Unlock()
-		return s.broadcast(msg.Src, body)
+	// Batch message, only sent by the other nodes
+	reply := func() {
+		_ = s.n.Reply(msg, s.withHighWater(map[string]any{
+			"type":      "broadcast_ok",
+			"encodings": supportedEncodings,
+		}, nil))
 	}
 
-	// Batch message
-	values := body["messages"].([]any)
+	if err := s.storePayloads(msg.Body); err != nil {
+		return err
+	}
+	values, err := s.decodeBatch(msg.Src, msg.Body)
+	if err != nil {
+		return err
+	}
+	s.learnHighWater(msg.Src, msg.Body)
+	if s.causal != nil {
+		go reply()
+		return s.causalBatchHandler(msg, body)
+	}
 	messages := make([]int, 0, len(values))
 	s.idsMu.Lock()
-	for _, v := range values {
-		message := int(v.(float64))
+	for _, message := range values {
 		if _, exists := s.ids[message]; exists {
 			continue
 		}
 		s.ids[message] = struct{}{}
+		s.arrivals = append(s.arrivals, message)
 		messages = append(messages, message)
 	}
 	s.idsMu.Unlock()
-	return s.batchBroadcast(msg.Src, messages)
-}
+	// Only acknowledged once stored
+	go reply()
 
-func (s *server) broadcast(src string, body map[string]any) error {
-	s.nodesMu.RLock()
-	n := s.tree.GetNode(s.id)
-	defer s.nodesMu.RUnlock()
-
-	var neighbors []string
-
-	if n.Parent != nil {
-		neighbors = append(neighbors, n.Parent.Entries[0].Value.(string))
+	if s.plumtree != nil {
+		// Values replicated for a quorum
+		for _, message := range messages {
+			s.plumtree.broadcast(msg.Src, message)
+		}
+		return nil
 	}
+	return s.batchBroadcast(msg.Src, body, messages)
+}
 
-	for _, children := range n.Children {
-		for _, entry := range children.Entries {
-			neighbors = append(neighbors, entry.Value.(string))
+// receive handles a value received from a client.
+func (s *server) receive(src string, body map[string]any, message int) error {
+	if s.plumtree != nil {
+		if s.addID(message) {
+			s.plumtree.broadcast(src, message)
 		}
+		return nil
 	}
-
-	message := int(body["message"].(float64))
-
-	s.broadcastsMu.Lock()
-	defer s.broadcastsMu.Unlock()
-	for _, dst := range neighbors {
-		if dst == src || dst == s.nodeID {
-			continue
+	if s.total != nil {
+		s.total.broadcast(message)
+		return nil
+	}
+	if s.causal != nil {
+		if s.causal.local(message) {
+			return s.broadcast(src, body, message)
 		}
+		return nil
+	}
 
-		s.broadcasts[dst] = append(s.broadcasts[dst], message)
+	if !s.addID(message) {
+		return nil
 	}
-	return nil
+	return s.broadcast(src, body, message)
 }
 
-func (s *server) batchBroadcast(src string, messages []int) error {
-	s.nodesMu.RLock()
-	n := s.tree.GetNode(s.id)
-	defer s.nodesMu.RUnlock()
-
-	var neighbors []string
-
-	if n.Parent != nil {
-		neighbors = append(neighbors, n.Parent.Entries[0].Value.(string))
+func (s *server) broadcast(src string, body map[string]any, message int) error {
+	if s.highWater != nil {
+		s.highWater.local(message)
+	}
+	s.learned.Add(1)
+	for _, dst := range s.targets(src, body) {
+		s.outbox.add(dst, []int{message})
 	}
+	return nil
+}
 
-	for _, children := range n.Children {
-		for _, entry := range children.Entries {
-			neighbors = append(neighbors, entry.Value.(string))
+func (s *server) batchBroadcast(src string, body map[string]any, messages []int) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	s.learned.Add(int64(len(messages)))
+	neighbors := s.targets(src, body)
+
+	if failover, ok := body["failover"].(string); ok {
+		// We relay on behalf of an unresponsive node, no need to wait for the
+		// next batch
+		for _, dst := range neighbors {
+			dst := dst
+			go s.relay(dst, messages, failover)
 		}
+		return nil
 	}
 
-	s.broadcastsMu.Lock()
-	defer s.broadcastsMu.Unlock()
 	for _, dst := range neighbors {
-		if dst == src || dst == s.nodeID {
-			continue
-		}
-
-		for _, message := range messages {
-			s.broadcasts[dst] = append(s.broadcasts[dst], message)
-		}
+		s.outbox.add(dst, messages)
 	}
 	return nil
 }
 
 func (s *server) batchRPC() {
-	s.broadcastsMu.Lock()
-	defer s.broadcastsMu.Unlock()
-
-	wg := sync.WaitGroup{}
-	for dst, messages := range s.broadcasts {
+	ready := s.outbox.ready(s.policy)
+	for dst, messages := range ready {
 		dst := dst
 		messages := messages
-		go func() {
-			if err := s.rpcWithRetry(dst, map[string]any{
-				"type":     "broadcast",
-				"messages": messages,
-			}, maxRetry); err != nil {
-				log.Error(err)
-			}
-		}()
+		go s.flush(dst, messages)
 	}
-	s.broadcasts = make(map[string][]int)
-	wg.Wait()
+	s.policy.observe(int(s.learned.Swap(0)), len(ready), len(s.n.NodeIDs()))
 }
 
-func (s *server) rpcWithRetry(dst string, body map[string]any, retry int) error {
-	var err error
-	for i := 0; i < retry; i++ {
-		if err = s.rpc(dst, body); err != nil {
-			// Sleep and retry
-			time.Sleep(100 * time.Duration(i) * time.Millisecond)
-			continue
+// flush sends the pending values of a peer. If the peer is unresponsive, the
+// values are relayed through an alternate node right away; they stay pending
+// until the peer acknowledges them. The values the peer already has according
+// to its high-water marks aren't sent.
+func (s *server) flush(dst string, messages []int) {
+	var covered []int
+	if s.highWater != nil {
+		messages, covered = s.highWater.uncovered(dst, messages)
+		if len(messages) == 0 {
+			s.outbox.ack(dst, covered)
+			return
 		}
-		return nil
 	}
-	return err
+
+	if s.members.IsLive(dst) {
+		if err := s.rpc(dst, s.batch(dst, messages, nil)); err == nil {
+			s.outbox.ack(dst, append(messages, covered...))
+			return
+		}
+	}
+
+	s.failover(dst, s.outbox.fail(dst, messages))
 }
 
 func (s *server) rpc(dst string, body map[string]any) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	_, err := s.n.SyncRPC(ctx, dst, body)
-	return err
+	res, err := s.n.SyncRPC(ctx, dst, s.members.Piggyback(body))
+	if err != nil {
+		s.markDown(dst)
+		return err
+	}
+	s.markUp(dst)
+	s.learnEncodings(dst, res.Body)
+	s.learnHighWater(dst, res.Body)
+	return nil
 }
 
+type readMsg struct {
+	Encoding string `json:"encoding"`
+	Cursor   *int   `json:"cursor"`
+}
+
+// readHandler returns all the ids or, if a cursor is provided, only the ids
+// learned since this cursor along with a new cursor. A cursor is a position in
+// the arrival log of the node, so it's only valid for the node that returned
+// it.
 func (s *server) readHandler(msg maelstrom.Message) error {
-	ids := s.getAllIDs()
+	var body readMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
 
-	return s.n.Reply(msg, map[string]any{
-		"type":     "read_ok",
-		"messages": ids,
-	})
+	res := map[string]any{
+		"type": "read_ok",
+	}
+	var ids []int
+	if body.Cursor == nil {
+		ids = s.getAllIDs()
+	} else {
+		since, cursor, err := s.getIDsSince(*body.Cursor)
+		if err != nil {
+			return err
+		}
+		ids = since
+		res["cursor"] = cursor
+	}
+
+	// The ranges only apply to integers, and they would sort the values in
+	// total-order mode
+	if body.Encoding == rangesEncoding && s.inline(ids) && s.total == nil {
+		res["ranges"] = encodeRanges(ids)
+	} else {
+		res["messages"] = s.messages(ids)
+	}
+	return s.n.Reply(msg, res)
+}
+
+// addID stores an id and returns whether it was unknown.
+func (s *server) addID(id int) bool {
+	s.idsMu.Lock()
+	defer s.idsMu.Unlock()
+	if _, exists := s.ids[id]; exists {
+		return false
+	}
+	s.ids[id] = struct{}{}
+	s.arrivals = append(s.arrivals, id)
+	return true
 }
 
 func (s *server) getAllIDs() []int {
 	s.idsMu.RLock()
-	ids := make([]int, 0, len(s.ids))
-	for id := range s.ids {
-		ids = append(ids, id)
-	}
+	ids := make([]int, len(s.arrivals))
+	copy(ids, s.arrivals)
 	s.idsMu.RUnlock()
 
 	return ids
 }
 
+// getIDsSince returns the ids learned since a cursor, along with the new
+// cursor.
+func (s *server) getIDsSince(cursor int) ([]int, int, error) {
+	s.idsMu.RLock()
+	defer s.idsMu.RUnlock()
+
+	if cursor < 0 || cursor > len(s.arrivals) {
+		return nil, 0, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("invalid cursor: %d", cursor))
+	}
+	ids := make([]int, len(s.arrivals)-cursor)
+	copy(ids, s.arrivals[cursor:])
+	return ids, len(s.arrivals), nil
+}
+
+type topologyMsg struct {
+	Topology map[string][]string `json:"topology"`
+}
+
 func (s *server) topologyHandler(msg maelstrom.Message) error {
-	tree := btree.NewWithIntComparator(len(s.n.NodeIDs()))
-	for i := 0; i < len(s.n.NodeIDs()); i++ {
-		tree.Put(i, fmt.Sprintf("n%d", i))
+	var t topologyMsg
+	if err := json.Unmarshal(msg.Body, &t); err != nil {
+		return err
+	}
+
+	graph, err := s.strategy.Build(s.n.NodeIDs(), t.Topology)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.Crash, err.Error())
 	}
 
 	s.nodesMu.Lock()
-	s.tree = tree
+	s.graph = graph
 	s.nodesMu.Unlock()
 
+	if s.plumtree != nil {
+		s.plumtree.reset(graph.Neighbors(s.nodeID), s.n.NodeIDs())
+	}
+	if s.total != nil {
+		s.total.reset(s.n.NodeIDs(), graph.Neighbors)
+	}
+
 	return s.n.Reply(msg, map[string]any{
 		"type": "topology_ok",
 	})
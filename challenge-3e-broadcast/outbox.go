@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Each peer has a persistent outbox: the values to send are merged into a
+// single pending set, there is at most one in-flight RPC per peer, and a value
+// is removed only once the peer acknowledged it. A failed RPC doesn't spawn any
+// retry: the pending values are simply resent (along with the new ones) at a
+// later batch, with a linear backoff.
+
+const (
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
+type outbox struct {
+	mu    sync.Mutex
+	peers map[string]*peerOutbox
+}
+
+type peerOutbox struct {
+	// Pending values along with the time they were queued
+	pending map[int]time.Time
+	// Pending values already relayed through an alternate node
+	relayed     map[int]struct{}
+	inFlight    bool
+	failures    int
+	nextAttempt time.Time
+}
+
+func newOutbox() *outbox {
+	return &outbox{peers: make(map[string]*peerOutbox)}
+}
+
+func (o *outbox) peer(dst string) *peerOutbox {
+	p, exists := o.peers[dst]
+	if !exists {
+		p = &peerOutbox{
+			pending: make(map[int]time.Time),
+			relayed: make(map[int]struct{}),
+		}
+		o.peers[dst] = p
+	}
+	return p
+}
+
+// add queues values for a peer.
+func (o *outbox) add(dst string, messages []int) {
+	if len(messages) == 0 {
+		return
+	}
+
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := o.peer(dst)
+	for _, message := range messages {
+		if _, exists := p.pending[message]; !exists {
+			p.pending[message] = now
+		}
+	}
+}
+
+// ready returns the peers that have pending values, no in-flight RPC, whose
+// backoff expired and that are due according to the batch policy, along with
+// their pending values. The peers are then marked as in-flight.
+func (o *outbox) ready(policy batchPolicy) map[string][]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	res := make(map[string][]int)
+	for dst, p := range o.peers {
+		if p.inFlight || len(p.pending) == 0 || now.Before(p.nextAttempt) {
+			continue
+		}
+		oldest := now
+		for _, queued := range p.pending {
+			if queued.Before(oldest) {
+				oldest = queued
+			}
+		}
+		if !policy.due(len(p.pending), now.Sub(oldest)) {
+			continue
+		}
+
+		messages := make([]int, 0, len(p.pending))
+		for message := range p.pending {
+			messages = append(messages, message)
+		}
+		p.inFlight = true
+		res[dst] = messages
+	}
+	return res
+}
+
+// ack removes the values acknowledged by a peer.
+func (o *outbox) ack(dst string, messages []int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := o.peer(dst)
+	for _, message := range messages {
+		delete(p.pending, message)
+		delete(p.relayed, message)
+	}
+	p.inFlight = false
+	p.failures = 0
+	p.nextAttempt = time.Time{}
+}
+
+// fail releases a peer after a failed RPC and returns the values that weren't
+// relayed yet (they are then considered as relayed).
+func (o *outbox) fail(dst string, messages []int) []int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := o.peer(dst)
+	p.inFlight = false
+	p.failures++
+	backoff := time.Duration(p.failures) * retryBackoff
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	p.nextAttempt = time.Now().Add(backoff)
+
+	var unrelayed []int
+	for _, message := range messages {
+		if _, exists := p.relayed[message]; !exists {
+			p.relayed[message] = struct{}{}
+			unrelayed = append(unrelayed, message)
+		}
+	}
+	return unrelayed
+}
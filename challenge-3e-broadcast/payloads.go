@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/teivah/gossip-glomers/payload"
+)
+
+// The values are tracked by their ID (see the payload package), so the batches,
+// the anti-entropy, plumtree and total-order messages only carry IDs. The
+// values that aren't their own ID (strings, objects, etc.) are attached to these
+// messages in a payloads field, and a node stores them before the IDs.
+
+type payloadsMsg struct {
+	Payloads map[int]any `json:"payloads"`
+}
+
+// withPayloads attaches the values of ids that aren't their own ID to a body.
+func (s *server) withPayloads(body map[string]any, ids []int) map[string]any {
+	s.idsMu.RLock()
+	defer s.idsMu.RUnlock()
+
+	var payloads map[int]any
+	for _, id := range ids {
+		if v, exists := s.values[id]; exists {
+			if payloads == nil {
+				payloads = make(map[int]any)
+			}
+			payloads[id] = v
+		}
+	}
+	if payloads != nil {
+		body["payloads"] = payloads
+	}
+	return body
+}
+
+// storePayloads stores the values attached to a message.
+func (s *server) storePayloads(data json.RawMessage) error {
+	var body payloadsMsg
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	if len(body.Payloads) == 0 {
+		return nil
+	}
+
+	s.idsMu.Lock()
+	defer s.idsMu.Unlock()
+	for id, v := range body.Payloads {
+		s.values[id] = v
+	}
+	return nil
+}
+
+// storeValue stores the value of a broadcast body received from a client and
+// returns its ID.
+func (s *server) storeValue(body map[string]any) (int, error) {
+	id, err := payload.ID(body)
+	if err != nil {
+		return 0, err
+	}
+	if message := body["message"]; !payload.Inline(message, id) {
+		s.idsMu.Lock()
+		s.values[id] = message
+		s.idsMu.Unlock()
+	}
+	return id, nil
+}
+
+// messages returns the values of ids.
+func (s *server) messages(ids []int) []any {
+	s.idsMu.RLock()
+	defer s.idsMu.RUnlock()
+
+	res := make([]any, len(ids))
+	for i, id := range ids {
+		if v, exists := s.values[id]; exists {
+			res[i] = v
+		} else {
+			res[i] = id
+		}
+	}
+	return res
+}
+
+// inline returns whether all the ids are their own value.
+func (s *server) inline(ids []int) bool {
+	s.idsMu.RLock()
+	defer s.idsMu.RUnlock()
+
+	for _, id := range ids {
+		if _, exists := s.values[id]; exists {
+			return false
+		}
+	}
+	return true
+}
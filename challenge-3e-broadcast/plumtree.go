@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/membership"
+)
+
+// Plumtree (Epidemic Broadcast Trees, Leitão et al.):
+//   - A message is eagerly pushed (gossip) to the eager peers, while only its
+//     ID is lazily announced (ihave) to the lazy peers
+//   - Initially, all the neighbors are eager peers, and the two nodes next to
+//     this one in the list of nodes are lazy peers. When a node receives a
+//     duplicate, it moves the sender to its lazy peers and asks it to do the
+//     same (prune). Hence, the eager peers converge to a spanning tree
+//   - If a node receives an announcement but not the message itself before a
+//     timeout, the tree is broken (e.g., partition). It moves the announcer to
+//     its eager peers and asks it for the message (graft), which repairs the
+//     tree
+//
+// As the broadcast values are unique, a value is its own message ID.
+
+const (
+	ihaveFrequency = 200 * time.Millisecond
+	ihaveTimeout   = time.Second
+	graftTimeout   = 500 * time.Millisecond
+)
+
+type plumtree struct {
+	s *server
+
+	mu    sync.Mutex
+	eager map[string]bool
+	lazy  map[string]bool
+	// Pending announcements per lazy peer
+	ihaves map[string][]int
+	// Nodes that announced a value we haven't received yet
+	missing map[int][]string
+	timers  map[int]*time.Timer
+}
+
+type plumtreeMsg struct {
+	Message  int   `json:"message"`
+	Messages []int `json:"messages"`
+}
+
+func newPlumtree(s *server) *plumtree {
+	return &plumtree{
+		s:       s,
+		eager:   make(map[string]bool),
+		lazy:    make(map[string]bool),
+		ihaves:  make(map[string][]int),
+		missing: make(map[int][]string),
+		timers:  make(map[int]*time.Timer),
+	}
+}
+
+func (p *plumtree) handle(n *maelstrom.Node, members *membership.Membership) {
+	n.Handle("gossip", members.Wrap(p.gossipHandler))
+	n.Handle("ihave", members.Wrap(p.ihaveHandler))
+	n.Handle("graft", members.Wrap(p.graftHandler))
+	n.Handle("prune", members.Wrap(p.pruneHandler))
+}
+
+// reset makes all the neighbors eager peers. The nodes next to this one in
+// nodeIDs (seen as a ring) that aren't neighbors start as lazy peers: over a
+// tree topology such as the default flat tree, no duplicate is ever received,
+// so there would otherwise be no lazy peer to announce the values and repair
+// the tree after a partition.
+func (p *plumtree) reset(neighbors, nodeIDs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.eager = make(map[string]bool, len(neighbors))
+	p.lazy = make(map[string]bool)
+	for _, neighbor := range neighbors {
+		if neighbor != p.s.nodeID {
+			p.eager[neighbor] = true
+		}
+	}
+	for i, nodeID := range nodeIDs {
+		if nodeID != p.s.nodeID {
+			continue
+		}
+		for _, peer := range []string{nodeIDs[(i+1)%len(nodeIDs)], nodeIDs[(i+len(nodeIDs)-1)%len(nodeIDs)]} {
+			if peer != p.s.nodeID && !p.eager[peer] {
+				p.lazy[peer] = true
+			}
+		}
+	}
+}
+
+// broadcast pushes a new value received from src.
+func (p *plumtree) broadcast(src string, message int) {
+	p.mu.Lock()
+	var eager []string
+	for peer := range p.eager {
+		if peer != src {
+			eager = append(eager, peer)
+		}
+	}
+	for peer := range p.lazy {
+		if peer != src {
+			p.ihaves[peer] = append(p.ihaves[peer], message)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, peer := range eager {
+		p.send(peer, p.s.withPayloads(map[string]any{
+			"type":    "gossip",
+			"message": message,
+		}, []int{message}))
+	}
+}
+
+func (p *plumtree) gossipHandler(msg maelstrom.Message) error {
+	var body plumtreeMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if err := p.s.storePayloads(msg.Body); err != nil {
+		return err
+	}
+
+	if !p.s.addID(body.Message) {
+		// Duplicate: the sender doesn't have to be part of the tree
+		p.mu.Lock()
+		delete(p.eager, msg.Src)
+		p.lazy[msg.Src] = true
+		p.mu.Unlock()
+		p.send(msg.Src, map[string]any{
+			"type": "prune",
+		})
+		return nil
+	}
+
+	p.mu.Lock()
+	if t, exists := p.timers[body.Message]; exists {
+		t.Stop()
+		delete(p.timers, body.Message)
+	}
+	delete(p.missing, body.Message)
+	delete(p.lazy, msg.Src)
+	p.eager[msg.Src] = true
+	p.mu.Unlock()
+
+	p.broadcast(msg.Src, body.Message)
+	return nil
+}
+
+func (p *plumtree) ihaveHandler(msg maelstrom.Message) error {
+	var body plumtreeMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, message := range body.Messages {
+		if p.received(message) {
+			continue
+		}
+		message := message
+		p.missing[message] = append(p.missing[message], msg.Src)
+		if _, exists := p.timers[message]; !exists {
+			p.timers[message] = time.AfterFunc(ihaveTimeout, func() {
+				p.timeout(message)
+			})
+		}
+	}
+	return nil
+}
+
+// timeout is triggered when a value was announced but not received in time. It
+// grafts the first node that announced it.
+func (p *plumtree) timeout(message int) {
+	p.mu.Lock()
+	announcers := p.missing[message]
+	// The value may have been received in the meantime through anti-entropy
+	if len(announcers) == 0 || p.received(message) {
+		delete(p.missing, message)
+		delete(p.timers, message)
+		p.mu.Unlock()
+		return
+	}
+
+	peer := announcers[0]
+	p.missing[message] = announcers[1:]
+	delete(p.lazy, peer)
+	p.eager[peer] = true
+	p.timers[message] = time.AfterFunc(graftTimeout, func() {
+		p.timeout(message)
+	})
+	p.mu.Unlock()
+
+	p.send(peer, map[string]any{
+		"type":     "graft",
+		"messages": []int{message},
+	})
+}
+
+func (p *plumtree) graftHandler(msg maelstrom.Message) error {
+	var body plumtreeMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	delete(p.lazy, msg.Src)
+	p.eager[msg.Src] = true
+	p.mu.Unlock()
+
+	for _, message := range body.Messages {
+		if !p.received(message) {
+			continue
+		}
+		p.send(msg.Src, p.s.withPayloads(map[string]any{
+			"type":    "gossip",
+			"message": message,
+		}, []int{message}))
+	}
+	return nil
+}
+
+func (p *plumtree) pruneHandler(msg maelstrom.Message) error {
+	p.mu.Lock()
+	delete(p.eager, msg.Src)
+	p.lazy[msg.Src] = true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *plumtree) received(message int) bool {
+	p.s.idsMu.RLock()
+	_, exists := p.s.ids[message]
+	p.s.idsMu.RUnlock()
+	return exists
+}
+
+// flushIHaves sends the pending announcements, one message per lazy peer.
+func (p *plumtree) flushIHaves() {
+	p.mu.Lock()
+	ihaves := p.ihaves
+	p.ihaves = make(map[string][]int)
+	p.mu.Unlock()
+
+	for peer, messages := range ihaves {
+		p.send(peer, map[string]any{
+			"type":     "ihave",
+			"messages": messages,
+		})
+	}
+}
+
+// send is fire-and-forget: a lost message is repaired by the ihave/graft
+// mechanism.
+func (p *plumtree) send(dst string, body map[string]any) {
+	if err := p.s.n.Send(dst, p.s.members.Piggyback(body)); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPlumtree runs the Plumtree mode over the default flat tree under
+// partitions, and checks that the lazy peers announced the values and that the
+// tree was repaired with grafts.
+func TestPlumtree(t *testing.T) {
+	o := scenario{
+		env:       []string{"BROADCAST_MODE=plumtree"},
+		nodes:     9,
+		ops:       300,
+		rate:      100,
+		latency:   20 * time.Millisecond,
+		partition: 2 * time.Second,
+		rounds:    2,
+		settle:    5 * time.Second,
+	}.run(t)
+
+	for _, typ := range []string{"ihave", "graft"} {
+		if o.cluster.Sent(typ) == 0 {
+			t.Errorf("no %s message sent", typ)
+		}
+	}
+	t.Logf("%d gossip, %d ihave, %d graft, %d prune", o.cluster.Sent("gossip"), o.cluster.Sent("ihave"), o.cluster.Sent("graft"), o.cluster.Sent("prune"))
+}
@@ -0,0 +1,6 @@
+#!/bin/bash
+# Runs the causal mode under a partition, some values being broadcast to two
+# nodes, and checks that read_causal never lists a value before its causal
+# dependencies (TestCausal). Pass -seed to replay a failure.
+
+go test -count=1 -v -run 'TestCausal$' . -args "$@"
@@ -0,0 +1,6 @@
+#!/bin/bash
+# Runs the Plumtree mode over the default flat tree under partitions, and checks
+# that the lazy peers announce the values and that the tree is repaired with
+# grafts (TestPlumtree). Pass -seed to replay a failure.
+
+go test -count=1 -v -run 'TestPlumtree$' . -args "$@"
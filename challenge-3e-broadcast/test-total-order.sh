@@ -0,0 +1,6 @@
+#!/bin/bash
+# Runs the total-order mode under partitions splitting the nodes differently,
+# so that the sequencer changes several times, and checks that every read is a
+# prefix of the longest one (TestTotalOrder). Pass -seed to replay a failure.
+
+go test -count=1 -v -run 'TestTotalOrder$' . -args "$@"
@@ -0,0 +1,672 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// In total-order mode (BROADCAST_MODE=total-order), a sequencer assigns a
+// global sequence number to each value, and all the nodes deliver the values in
+// sequence order. Hence, read returns the same ordered list on every node (or a
+// prefix of it).
+//
+//   - A node submits the values received from clients to the sequencer
+//     (order_submit) and resubmits them until it delivers them, so a value
+//     sequenced twice is only delivered once
+//   - The sequencer streams its log to each node (order_append) and an entry is
+//     committed once a majority of the nodes stored it. A node delivers the
+//     committed entries in order
+//   - The sequencer is initially the root of the flat tree (more generally, the
+//     node with the most neighbors). The current sequencer and its epoch are
+//     stored in lin-kv. A node that doesn't hear from the sequencer for a while
+//     elects itself by CAS-ing the next epoch
+//   - A new sequencer first recovers the log from a majority of the nodes
+//     (order_recover), keeping for each sequence number the entry with the
+//     highest epoch. As a committed entry is stored by a majority, it can't be
+//     lost. As in Paxos, the recovered entries are proposed again with the new
+//     epoch: otherwise, an entry sequenced by a former sequencer that only
+//     reached a few nodes could later win over a committed entry with an older
+//     epoch. It then appends a no-op entry to commit the recovered entries
+//   - A node rejects the messages from an older epoch, which makes a former
+//     sequencer step down
+
+const (
+	orderFrequency     = 100 * time.Millisecond
+	heartbeatFrequency = 500 * time.Millisecond
+	electionTimeout    = 2 * time.Second
+	submitTimeout      = time.Second
+	maxAppendEntries   = 512
+	sequencerKey       = "sequencer"
+)
+
+type entry struct {
+	Epoch   int  `json:"epoch"`
+	Message int  `json:"message"`
+	Noop    bool `json:"noop,omitempty"`
+}
+
+type sequencer struct {
+	Epoch int    `json:"epoch"`
+	Node  string `json:"node"`
+}
+
+type orderSubmitMsg struct {
+	Messages []int `json:"messages"`
+}
+
+type orderAppendMsg struct {
+	Epoch   int     `json:"epoch"`
+	From    int     `json:"from"`
+	Entries []entry `json:"entries"`
+	Commit  int     `json:"commit"`
+}
+
+type orderAppendOkMsg struct {
+	Epoch   int `json:"epoch"`
+	Matched int `json:"matched"`
+}
+
+type orderRecoverMsg struct {
+	Epoch int `json:"epoch"`
+	From  int `json:"from"`
+}
+
+type orderRecoverOkMsg struct {
+	Epoch   int     `json:"epoch"`
+	Entries []entry `json:"entries"`
+}
+
+type totalOrder struct {
+	s  *server
+	kv *maelstrom.KV
+
+	// Randomized so that the nodes don't all run for election at once
+	timeout time.Duration
+
+	mu        sync.Mutex
+	epoch     int
+	sequencer string
+	lastHeard time.Time
+	// Entries by sequence number, starting at 1
+	entries map[int]entry
+	// Highest sequence number known to match the log of the sequencer
+	matched   int
+	commit    int
+	delivered int
+	// Delivered values
+	seen map[int]bool
+	// Values received from clients and not delivered yet, along with the last
+	// time they were submitted
+	pending map[int]time.Time
+
+	// Sequencer state
+	leading bool
+	length  int
+	// Values part of the log
+	sequenced map[int]bool
+	// Highest sequence number stored per node, missing if unknown
+	acked    map[string]int
+	inFlight map[string]bool
+	lastSent map[string]time.Time
+	// Last commit sent per node
+	sentCommit map[string]int
+}
+
+func newTotalOrder(s *server) *totalOrder {
+	return &totalOrder{
+		s:          s,
+		kv:         maelstrom.NewLinKV(s.n),
+		timeout:    electionTimeout + time.Duration(rand.Int63n(int64(electionTimeout))),
+		entries:    make(map[int]entry),
+		seen:       make(map[int]bool),
+		pending:    make(map[int]time.Time),
+		sequenced:  make(map[int]bool),
+		acked:      make(map[string]int),
+		inFlight:   make(map[string]bool),
+		lastSent:   make(map[string]time.Time),
+		sentCommit: make(map[string]int),
+	}
+}
+
+func (t *totalOrder) handle(n *maelstrom.Node) {
+	n.Handle("order_submit", t.s.members.Wrap(t.submitHandler))
+	n.Handle("order_append", t.s.members.Wrap(t.appendHandler))
+	n.Handle("order_recover", t.s.members.Wrap(t.recoverHandler))
+}
+
+// reset sets the initial sequencer: the node with the most neighbors.
+func (t *totalOrder) reset(nodeIDs []string, neighbors func(string) []string) {
+	sorted := append([]string(nil), nodeIDs...)
+	sort.Strings(sorted)
+	initial := ""
+	for _, nodeID := range sorted {
+		if initial == "" || len(neighbors(nodeID)) > len(neighbors(initial)) {
+			initial = nodeID
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.epoch != 0 {
+		return
+	}
+	t.sequencer = initial
+	t.lastHeard = time.Now()
+	if initial == t.s.nodeID {
+		t.lead()
+	}
+}
+
+// broadcast handles a value received from a client.
+func (t *totalOrder) broadcast(message int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen[message] {
+		return
+	}
+	if t.leading {
+		t.sequence([]int{message})
+		return
+	}
+	// Submitted at the next tick
+	t.pending[message] = time.Time{}
+}
+
+// tick is called every orderFrequency.
+func (t *totalOrder) tick() {
+	t.mu.Lock()
+	leading := t.leading
+	sequencer := t.sequencer
+	elect := !leading && time.Since(t.lastHeard) > t.timeout
+	var submit []int
+	if !leading && sequencer != "" {
+		now := time.Now()
+		for message, sent := range t.pending {
+			if now.Sub(sent) > submitTimeout {
+				submit = append(submit, message)
+				t.pending[message] = now
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	if leading {
+		t.replicate()
+		return
+	}
+	if elect {
+		t.elect()
+		return
+	}
+	if len(submit) != 0 {
+		go t.submit(sequencer, submit)
+	}
+}
+
+func (t *totalOrder) submit(sequencer string, messages []int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := t.s.n.SyncRPC(ctx, sequencer, t.s.members.Piggyback(t.s.withPayloads(map[string]any{
+		"type":     "order_submit",
+		"messages": messages,
+	}, messages)))
+	if err != nil {
+		// Resubmitted after submitTimeout, possibly to a new sequencer
+		log.Warnf("failed to submit messages to %s: %v", sequencer, err)
+	}
+}
+
+func (t *totalOrder) submitHandler(msg maelstrom.Message) error {
+	var body orderSubmitMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if err := t.s.storePayloads(msg.Body); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.leading {
+		return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "not the sequencer")
+	}
+	t.sequence(body.Messages)
+	return t.s.n.Reply(msg, map[string]any{
+		"type": "order_submit_ok",
+	})
+}
+
+// sequence appends values to the log. It must be called by the sequencer while
+// holding the lock.
+func (t *totalOrder) sequence(messages []int) {
+	for _, message := range messages {
+		if t.sequenced[message] {
+			continue
+		}
+		t.sequenced[message] = true
+		t.length++
+		t.entries[t.length] = entry{Epoch: t.epoch, Message: message}
+	}
+	t.matched = t.length
+	t.advanceCommit()
+}
+
+// replicate sends the entries each node is missing, or a heartbeat.
+func (t *totalOrder) replicate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.leading {
+		return
+	}
+
+	now := time.Now()
+	for _, dst := range t.s.n.NodeIDs() {
+		if dst == t.s.nodeID || t.inFlight[dst] {
+			continue
+		}
+		// If we don't know what the node stored, we first send an empty
+		// append to find out
+		from := t.length + 1
+		if acked, known := t.acked[dst]; known {
+			from = acked + 1
+		}
+		if from > t.length && t.commit <= t.sentCommit[dst] && now.Sub(t.lastSent[dst]) < heartbeatFrequency {
+			continue
+		}
+
+		entries := make([]entry, 0)
+		for i := from; i <= t.length && len(entries) < maxAppendEntries; i++ {
+			entries = append(entries, t.entries[i])
+		}
+		t.inFlight[dst] = true
+		t.lastSent[dst] = now
+		t.sentCommit[dst] = t.commit
+		go t.append(dst, orderAppendMsg{
+			Epoch:   t.epoch,
+			From:    from,
+			Entries: entries,
+			Commit:  t.commit,
+		})
+	}
+}
+
+func (t *totalOrder) append(dst string, body orderAppendMsg) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := t.s.n.SyncRPC(ctx, dst, t.s.members.Piggyback(t.s.withPayloads(map[string]any{
+		"type":    "order_append",
+		"epoch":   body.Epoch,
+		"from":    body.From,
+		"entries": body.Entries,
+		"commit":  body.Commit,
+	}, messagesOf(body.Entries))))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight[dst] = false
+	if err != nil {
+		return
+	}
+	var reply orderAppendOkMsg
+	if err := json.Unmarshal(res.Body, &reply); err != nil {
+		log.Error(err)
+		return
+	}
+	if reply.Epoch > t.epoch {
+		t.follow(reply.Epoch, "")
+		return
+	}
+	if !t.leading || reply.Epoch != t.epoch {
+		return
+	}
+	t.acked[dst] = reply.Matched
+	t.advanceCommit()
+}
+
+// advanceCommit commits the entries stored by a majority. As in Raft, only the
+// entries of the current epoch are committed by counting, the previous ones
+// being committed along with them.
+func (t *totalOrder) advanceCommit() {
+	nodeIDs := t.s.n.NodeIDs()
+	matched := make([]int, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		if nodeID == t.s.nodeID {
+			matched = append(matched, t.length)
+			continue
+		}
+		matched = append(matched, t.acked[nodeID])
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(matched)))
+
+	candidate := matched[len(matched)/2]
+	if candidate > t.commit && t.entries[candidate].Epoch == t.epoch {
+		t.commit = candidate
+		t.deliver()
+	}
+}
+
+func (t *totalOrder) appendHandler(msg maelstrom.Message) error {
+	var body orderAppendMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if err := t.s.storePayloads(msg.Body); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if body.Epoch < t.epoch {
+		return t.s.n.Reply(msg, map[string]any{
+			"type":    "order_append_ok",
+			"epoch":   t.epoch,
+			"matched": t.matched,
+		})
+	}
+	if body.Epoch > t.epoch {
+		t.follow(body.Epoch, msg.Src)
+	}
+	t.sequencer = msg.Src
+	t.lastHeard = time.Now()
+
+	if body.From <= t.matched+1 {
+		for i, e := range body.Entries {
+			seq := body.From + i
+			if seq > t.delivered {
+				t.entries[seq] = e
+			}
+		}
+		if last := body.From + len(body.Entries) - 1; last > t.matched {
+			t.matched = last
+		}
+	}
+	if body.Commit > t.commit {
+		t.commit = body.Commit
+	}
+	t.deliver()
+
+	return t.s.n.Reply(msg, map[string]any{
+		"type":    "order_append_ok",
+		"epoch":   t.epoch,
+		"matched": t.matched,
+	})
+}
+
+// deliver delivers the committed entries in order. It must be called while
+// holding the lock.
+func (t *totalOrder) deliver() {
+	for t.delivered < t.commit && t.delivered < t.matched {
+		e, exists := t.entries[t.delivered+1]
+		if !exists {
+			return
+		}
+		t.delivered++
+		if e.Noop || t.seen[e.Message] {
+			continue
+		}
+		t.seen[e.Message] = true
+		delete(t.pending, e.Message)
+		t.s.addID(e.Message)
+	}
+}
+
+// elect makes the node the sequencer if the current one didn't change in the
+// meantime.
+func (t *totalOrder) elect() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.mu.Lock()
+	current := sequencer{Epoch: t.epoch, Node: t.sequencer}
+	t.lastHeard = time.Now()
+	t.mu.Unlock()
+
+	stored, err := t.readSequencer(ctx)
+	if err != nil {
+		log.Warnf("failed to read the sequencer: %v", err)
+		return
+	}
+	if stored != nil {
+		if stored.Epoch > current.Epoch {
+			t.mu.Lock()
+			if stored.Epoch > t.epoch {
+				t.follow(stored.Epoch, stored.Node)
+			}
+			t.mu.Unlock()
+			return
+		}
+		current = *stored
+	}
+
+	next := sequencer{Epoch: current.Epoch + 1, Node: t.s.nodeID}
+	if current.Node == t.s.nodeID {
+		// We already won the election but failed to recover
+		next = current
+	} else if err := t.kv.CompareAndSwap(ctx, sequencerKey, current, next, true); err != nil {
+		log.Warnf("failed to elect %s: %v", t.s.nodeID, err)
+		return
+	}
+	log.Infof("%s elected as sequencer for epoch %d", t.s.nodeID, next.Epoch)
+
+	t.mu.Lock()
+	if next.Epoch < t.epoch {
+		t.mu.Unlock()
+		return
+	}
+	t.follow(next.Epoch, t.s.nodeID)
+	from := t.delivered + 1
+	t.mu.Unlock()
+
+	t.recover(next.Epoch, from)
+}
+
+func (t *totalOrder) readSequencer(ctx context.Context) (*sequencer, error) {
+	v, err := t.kv.Read(ctx, sequencerKey)
+	if err != nil {
+		var rpcErr *maelstrom.RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.KeyDoesNotExist {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var res sequencer
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// recover collects the log entries from a majority of the nodes, then starts
+// sequencing.
+func (t *totalOrder) recover(epoch, from int) {
+	nodeIDs := t.s.n.NodeIDs()
+	replies := make(chan []entry, len(nodeIDs))
+	for _, dst := range nodeIDs {
+		if dst == t.s.nodeID {
+			continue
+		}
+		dst := dst
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			res, err := t.s.n.SyncRPC(ctx, dst, t.s.members.Piggyback(map[string]any{
+				"type":  "order_recover",
+				"epoch": epoch,
+				"from":  from,
+			}))
+			if err != nil {
+				replies <- nil
+				return
+			}
+			var reply orderRecoverOkMsg
+			if err := json.Unmarshal(res.Body, &reply); err != nil || reply.Epoch != epoch {
+				replies <- nil
+				return
+			}
+			if err := t.s.storePayloads(res.Body); err != nil {
+				replies <- nil
+				return
+			}
+			if reply.Entries == nil {
+				reply.Entries = make([]entry, 0)
+			}
+			replies <- reply.Entries
+		}()
+	}
+
+	t.mu.Lock()
+	logs := [][]entry{t.entriesFrom(from)}
+	t.mu.Unlock()
+	for i := 0; i < len(nodeIDs)-1; i++ {
+		if entries := <-replies; entries != nil {
+			logs = append(logs, entries)
+		}
+	}
+	if len(logs) <= len(nodeIDs)/2 {
+		log.Warnf("%s failed to recover the log from a majority", t.s.nodeID)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.epoch != epoch {
+		return
+	}
+
+	// For each sequence number, keep the entry with the highest epoch and
+	// propose it again with ours
+	length := t.delivered
+	for seq := from; ; seq++ {
+		var best *entry
+		for _, entries := range logs {
+			if i := seq - from; i < len(entries) && (best == nil || entries[i].Epoch > best.Epoch) {
+				best = &entries[i]
+			}
+		}
+		if best == nil {
+			break
+		}
+		e := *best
+		e.Epoch = epoch
+		t.entries[seq] = e
+		length = seq
+	}
+
+	t.lead()
+	t.length = length
+	t.sequenced = make(map[int]bool)
+	for message := range t.seen {
+		t.sequenced[message] = true
+	}
+	for seq := t.delivered + 1; seq <= length; seq++ {
+		t.sequenced[t.entries[seq].Message] = true
+	}
+	t.length++
+	t.entries[t.length] = entry{Epoch: t.epoch, Noop: true}
+	t.matched = t.length
+
+	// Our own pending values
+	var pending []int
+	for message := range t.pending {
+		pending = append(pending, message)
+	}
+	t.sequence(pending)
+}
+
+// entriesFrom returns the contiguous entries starting at from. It must be
+// called while holding the lock.
+func (t *totalOrder) entriesFrom(from int) []entry {
+	entries := make([]entry, 0)
+	for seq := from; ; seq++ {
+		e, exists := t.entries[seq]
+		if !exists {
+			return entries
+		}
+		entries = append(entries, e)
+	}
+}
+
+// messagesOf returns the values of entries.
+func messagesOf(entries []entry) []int {
+	messages := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if !e.Noop {
+			messages = append(messages, e.Message)
+		}
+	}
+	return messages
+}
+
+func (t *totalOrder) recoverHandler(msg maelstrom.Message) error {
+	var body orderRecoverMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if body.Epoch < t.epoch {
+		return t.s.n.Reply(msg, map[string]any{
+			"type":  "order_recover_ok",
+			"epoch": t.epoch,
+		})
+	}
+	if body.Epoch > t.epoch {
+		t.follow(body.Epoch, msg.Src)
+	}
+	t.lastHeard = time.Now()
+
+	entries := t.entriesFrom(body.From)
+	return t.s.n.Reply(msg, t.s.withPayloads(map[string]any{
+		"type":    "order_recover_ok",
+		"epoch":   t.epoch,
+		"entries": entries,
+	}, messagesOf(entries)))
+}
+
+// lead starts sequencing. It must be called while holding the lock.
+func (t *totalOrder) lead() {
+	t.leading = true
+	t.acked = make(map[string]int)
+	t.inFlight = make(map[string]bool)
+	t.lastSent = make(map[string]time.Time)
+	t.sentCommit = make(map[string]int)
+}
+
+// follow moves to a newer epoch. It must be called while holding the lock.
+func (t *totalOrder) follow(epoch int, sequencer string) {
+	if t.leading {
+		// A former sequencer can learn about the new epoch from any message,
+		// and its own values that weren't delivered yet have to be resubmitted
+		log.Infof("%s steps down, epoch %d is over", t.s.nodeID, t.epoch)
+		for seq := t.delivered + 1; seq <= t.length; seq++ {
+			if e := t.entries[seq]; !e.Noop && !t.seen[e.Message] {
+				t.pending[e.Message] = time.Time{}
+			}
+		}
+	}
+
+	t.epoch = epoch
+	t.sequencer = sequencer
+	t.leading = false
+	t.lastHeard = time.Now()
+	// Only the delivered entries are known to match the log of the new
+	// sequencer
+	t.matched = t.delivered
+	for message := range t.pending {
+		t.pending[message] = time.Time{}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTotalOrder runs the total-order mode under partitions splitting the
+// nodes differently, so that the sequencer changes several times, and checks
+// that every read, including those made during the run, is a prefix of the
+// longest one.
+func TestTotalOrder(t *testing.T) {
+	o := scenario{
+		env:        []string{"BROADCAST_MODE=total-order"},
+		nodes:      5,
+		ops:        600,
+		rate:       50,
+		latency:    20 * time.Millisecond,
+		partition:  10 * time.Second,
+		rounds:     4,
+		settle:     10 * time.Second,
+		dup:        0.2,
+		readDuring: true,
+	}.run(t)
+
+	if diverging := checkTotalOrder(o.reads); diverging > 0 {
+		t.Errorf("%d of %d reads aren't a prefix of the longest one", diverging, len(o.reads))
+	}
+}
+
+// checkTotalOrder returns the number of reads that aren't a prefix of the
+// longest one.
+func checkTotalOrder(reads [][]int) int {
+	var longest []int
+	for _, read := range reads {
+		if len(read) > len(longest) {
+			longest = read
+		}
+	}
+
+	diverging := 0
+	for _, read := range reads {
+		for i, message := range read {
+			if message != longest[i] {
+				diverging++
+				break
+			}
+		}
+	}
+	return diverging
+}
@@ -0,0 +1,20 @@
+module github.com/teivah/gossip-glomers/challenge-3f-grow-only-set
+
+go 1.20
+
+require (
+	github.com/jepsen-io/maelstrom/demo/go v0.0.0-20230113211434-22f433519054
+	github.com/sirupsen/logrus v1.9.0
+	github.com/teivah/gossip-glomers/crdt v0.0.0
+	github.com/teivah/gossip-glomers/topology v0.0.0
+)
+
+require (
+	github.com/emirpasic/gods v1.18.1 // indirect
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+)
+
+replace (
+	github.com/teivah/gossip-glomers/crdt => ../crdt
+	github.com/teivah/gossip-glomers/topology => ../topology
+)
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/crdt"
+	"github.com/teivah/gossip-glomers/topology"
+)
+
+const (
+	defaultBatchInterval = 200 * time.Millisecond
+	maxDeltas            = 10000
+)
+
+func init() {
+	f, err := os.OpenFile("/tmp/maelstrom.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		panic(err)
+	}
+	log.SetOutput(f)
+}
+
+func main() {
+	strategy, err := topology.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if strategy.Name() == topology.Provided {
+		// The g-set workload doesn't send any topology message
+		log.Fatalf("the %s topology isn't supported", topology.Provided)
+	}
+	interval, err := batchIntervalFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n := maelstrom.NewNode()
+	s := &server{
+		n:        n,
+		strategy: strategy,
+		elements: crdt.NewDeltaLog(crdt.NewGSet[string](), crdt.NewGSet[string], maxDeltas),
+		peers:    make(map[string]*peer),
+	}
+
+	n.Handle("init", s.initHandler)
+	n.Handle("add", s.addHandler)
+	n.Handle("read", s.readHandler)
+	n.Handle("replicate", s.replicateHandler)
+
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+				s.replicate()
+			}
+		}
+	}()
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func batchIntervalFromEnv() (time.Duration, error) {
+	v := os.Getenv("BATCH_INTERVAL")
+	if v == "" {
+		return defaultBatchInterval, nil
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid BATCH_INTERVAL: %w", err)
+	}
+	return interval, nil
+}
+
+type server struct {
+	n        *maelstrom.Node
+	nodeID   string
+	strategy topology.Strategy
+
+	mu sync.Mutex
+	// Canonical encodings of the elements
+	elements  *crdt.DeltaLog[*crdt.GSet[string]]
+	neighbors []string
+	peers     map[string]*peer
+}
+
+func (s *server) initHandler(_ maelstrom.Message) error {
+	graph, err := s.strategy.Build(s.n.NodeIDs(), nil)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.Crash, err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeID = s.n.ID()
+	s.neighbors = graph.Neighbors(s.nodeID)
+	return nil
+}
+
+type addMsg struct {
+	Element json.RawMessage `json:"element"`
+}
+
+func (s *server) addHandler(msg maelstrom.Message) error {
+	var body addMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if body.Element == nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, "missing element")
+	}
+	element, err := canonical(body.Element)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+
+	s.mu.Lock()
+	if !s.elements.State().Contains(element) {
+		s.elements.Apply(s.elements.State().AddDelta(element))
+	}
+	s.mu.Unlock()
+
+	return s.n.Reply(msg, map[string]any{
+		"type": "add_ok",
+	})
+}
+
+func (s *server) readHandler(msg maelstrom.Message) error {
+	s.mu.Lock()
+	elements := s.elements.State().Elements()
+	s.mu.Unlock()
+
+	return s.n.Reply(msg, map[string]any{
+		"type":  "read_ok",
+		"value": raw(elements),
+	})
+}
+
+// canonical returns the canonical encoding of a JSON value, so that two equal
+// values have the same encoding whatever the order of their object keys.
+func canonical(data json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keeps the numbers as they were sent instead of converting them to floats
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return "", err
+	}
+	res, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
+// raw converts canonical encodings back to JSON values.
+func raw(elements []string) []json.RawMessage {
+	res := make([]json.RawMessage, 0, len(elements))
+	for _, element := range elements {
+		res = append(res, json.RawMessage(element))
+	}
+	return res
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/crdt"
+)
+
+// Each node replicates its set to its neighbors in the broadcast graph
+// (BROADCAST_TOPOLOGY, flat-tree by default; see the topology package), the
+// same way as #3e batches its values:
+//   - Every BATCH_INTERVAL (200ms by default), a node sends each neighbor, in a
+//     single replicate message, the elements it didn't acknowledge yet
+//   - There is at most one in-flight message per neighbor, and a failed one
+//     isn't retried right away: its elements stay pending and are resent (along
+//     with the new ones) at a later batch, with a linear backoff
+//
+// Unlike #3e, the set is a G-set CRDT: a node merges the elements it receives
+// by union, so a message can be duplicated or reordered without any harm. What
+// a neighbor is missing is tracked by a crdt.DeltaLog: each add is a delta
+// numbered by a sequence, a neighbor acknowledges the sequence number it merged
+// up to, the new elements received from a neighbor are forwarded to the other
+// ones, and a neighbor lagging more than maxDeltas behind is sent the full set.
+
+const (
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
+type peer struct {
+	inFlight    bool
+	failures    int
+	nextAttempt time.Time
+}
+
+type replicateMsg struct {
+	Elements []json.RawMessage `json:"elements"`
+}
+
+// replicate sends each neighbor the elements it didn't acknowledge.
+func (s *server) replicate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, dst := range s.neighbors {
+		p, exists := s.peers[dst]
+		if !exists {
+			p = &peer{}
+			s.peers[dst] = p
+		}
+		if p.inFlight || now.Before(p.nextAttempt) {
+			continue
+		}
+		delta, seq, ok := s.elements.Pending(dst)
+		if !ok {
+			continue
+		}
+		p.inFlight = true
+		go s.send(dst, delta, seq)
+	}
+}
+
+func (s *server) send(dst string, delta *crdt.GSet[string], seq int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := s.n.SyncRPC(ctx, dst, map[string]any{
+		"type":     "replicate",
+		"elements": raw(delta.Elements()),
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.peers[dst]
+	p.inFlight = false
+	if err != nil {
+		log.Warnf("failed to replicate to %s: %v", dst, err)
+		p.failures++
+		backoff := retryBackoff * time.Duration(p.failures)
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		p.nextAttempt = time.Now().Add(backoff)
+		return
+	}
+	p.failures = 0
+	s.elements.Ack(dst, seq)
+}
+
+func (s *server) replicateHandler(msg maelstrom.Message) error {
+	var body replicateMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	delta := crdt.NewGSet[string]()
+	for _, v := range body.Elements {
+		element, err := canonical(v)
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+		delta.Add(element)
+	}
+
+	s.mu.Lock()
+	s.elements.Forward(msg.Src, delta)
+	s.mu.Unlock()
+
+	return s.n.Reply(msg, map[string]any{
+		"type": "replicate_ok",
+	})
+}
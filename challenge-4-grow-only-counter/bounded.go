@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// With COUNTER_FLOOR (kv mode only), the counter is bounded: its value can't go
+// below the floor, even across partitions (e.g., inventory that must not go
+// below zero). It's an escrow-based bounded counter:
+//   - Each node holds rights to decrement, stored in its bucket along with its
+//     value; the rights of all the nodes sum up to at most the value minus the
+//     floor
+//   - The counter starts at 0, so the floor must be negative or zero; the rights
+//     above the floor are initially held by the first node
+//   - An increment gives rights to the node that applied it, and a decrement
+//     consumes rights of the node
+//   - A node lacking rights for a decrement asks the other nodes, one at a time,
+//     to transfer some of theirs (rights_request). A node grants what it can
+//     spare and stores its reduced rights before replying
+//   - If the node still lacks rights, the decrement is rejected with
+//     PreconditionFailed
+//
+// A node never spends rights it doesn't hold, so the floor holds whatever the
+// failures. The other way around, rights granted by a node whose reply is lost
+// are lost as well: this reduces the number of decrements that can be accepted,
+// never the safety.
+
+type bound struct {
+	enabled bool
+	floor   int
+}
+
+func boundFromEnv() (bound, error) {
+	v := os.Getenv("COUNTER_FLOOR")
+	if v == "" {
+		return bound{}, nil
+	}
+	floor, err := strconv.Atoi(v)
+	if err != nil {
+		return bound{}, fmt.Errorf("invalid COUNTER_FLOOR: %w", err)
+	}
+	if floor > 0 {
+		return bound{}, fmt.Errorf("COUNTER_FLOOR must be negative or zero as the counter starts at 0")
+	}
+	return bound{enabled: true, floor: floor}, nil
+}
+
+// missingRights is the error of a decrement exceeding the rights of the node,
+// by the given amount.
+type missingRights int
+
+func (m missingRights) Error() string {
+	return fmt.Sprintf("%d rights missing", int(m))
+}
+
+// initialRights returns the rights of the node at init.
+func (s *server) initialRights() int {
+	if !s.bound.enabled {
+		return 0
+	}
+	nodeIDs := s.n.NodeIDs()
+	if len(nodeIDs) == 0 || s.nodeID != nodeIDs[0] {
+		return 0
+	}
+	return -s.bound.floor
+}
+
+// updateBounded applies an add and, if the node lacks rights, asks the other
+// nodes for some before retrying it once.
+func (s *server) updateBounded(c change) error {
+	err := s.update(c)
+	var missing missingRights
+	if !errors.As(err, &missing) {
+		return err
+	}
+
+	if granted := s.requestRights(int(missing)); granted > 0 {
+		if err := s.update(func(b bucket) (bucket, error) {
+			b.Rights += granted
+			return b, nil
+		}); err != nil {
+			log.Errorf("%s lost %d granted rights: %v", s.nodeID, granted, err)
+			return err
+		}
+	}
+
+	err = s.update(c)
+	if errors.As(err, &missing) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+			fmt.Sprintf("the counter can't go below %d", s.bound.floor))
+	}
+	return err
+}
+
+type rightsMsg struct {
+	Amount  int `json:"amount"`
+	Granted int `json:"granted"`
+}
+
+// requestRights asks the other nodes, one at a time, for rights until needed
+// were granted, and returns the rights granted.
+func (s *server) requestRights(needed int) int {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	nodeIDs := s.n.NodeIDs()
+	granted := 0
+	for i := 1; i < len(nodeIDs) && granted < needed; i++ {
+		// Starts from the next node so that the requests are spread
+		nodeID := nodeIDs[(s.id+i)%len(nodeIDs)]
+		if !s.members.IsLive(nodeID) {
+			continue
+		}
+		res, err := s.n.SyncRPC(ctx, nodeID, s.members.Piggyback(map[string]any{
+			"type":   "rights_request",
+			"amount": needed - granted,
+		}))
+		if err != nil {
+			log.Warnf("failed to request rights from %s: %v", nodeID, err)
+			continue
+		}
+		var body rightsMsg
+		if err := json.Unmarshal(res.Body, &body); err != nil {
+			log.Warnf("invalid rights from %s: %v", nodeID, err)
+			continue
+		}
+		granted += body.Granted
+	}
+	return granted
+}
+
+// rightsHandler grants up to the requested amount of rights.
+func (s *server) rightsHandler(msg maelstrom.Message) error {
+	var body rightsMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	granted := 0
+	if err := s.update(func(b bucket) (bucket, error) {
+		granted = body.Amount
+		if b.Rights < granted {
+			granted = b.Rights
+		}
+		if granted <= 0 {
+			granted = 0
+			return b, errUnchanged
+		}
+		b.Rights -= granted
+		return b, nil
+	}); err != nil {
+		return err
+	}
+
+	return s.n.Reply(msg, map[string]any{
+		"type":    "rights_request_ok",
+		"granted": granted,
+	})
+}
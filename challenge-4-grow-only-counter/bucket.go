@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// A client retrying an add after a timeout could get it counted twice, so add
+// accepts an optional idempotency key. A node remembers the last maxKeys keys
+// it applied and ignores an add whose key was already applied. In kv mode, the
+// keys are stored in the bucket of the node along with its value, so that both
+// are written at once.
+
+const maxKeys = 1024
+
+// bucket is the value stored by a node in seq-kv.
+type bucket struct {
+	Value int      `json:"value"`
+	Keys  []string `json:"keys"`
+	// Only used by a bounded counter
+	Rights int `json:"rights,omitempty"`
+}
+
+// change computes the new bucket of the node from the current one. It returns
+// errUnchanged if there is nothing to write.
+type change func(b bucket) (bucket, error)
+
+var errUnchanged = errors.New("unchanged bucket")
+
+// initialBucket returns the bucket of the node at init.
+func (s *server) initialBucket() bucket {
+	return bucket{Keys: make([]string, 0), Rights: s.initialRights()}
+}
+
+// addChange adds delta to the bucket unless its key was already applied.
+func (s *server) addChange(delta int, key string) change {
+	return func(b bucket) (bucket, error) {
+		keys, applied := applyKey(b.Keys, key)
+		if !applied {
+			return b, errUnchanged
+		}
+		if s.bound.enabled {
+			if delta < 0 && b.Rights < -delta {
+				return b, missingRights(-delta - b.Rights)
+			}
+			b.Rights += delta
+		}
+		b.Keys = keys
+		b.Value += delta
+		return b, nil
+	}
+}
+
+// readBucket returns the bucket of the node, along with its raw value.
+func (s *server) readBucket(ctx context.Context) (bucket, any, error) {
+	v, err := s.kv.Read(ctx, s.nodeID)
+	if err != nil {
+		return bucket{}, nil, err
+	}
+	// The value is decoded by the client as a generic JSON value
+	data, err := json.Marshal(v)
+	if err != nil {
+		return bucket{}, nil, err
+	}
+	var b bucket
+	if err := json.Unmarshal(data, &b); err != nil {
+		return bucket{}, nil, err
+	}
+	return b, v, nil
+}
+
+// applyKey records a key among the applied ones and returns false if it was
+// already applied. An empty key is never considered as applied.
+func applyKey(keys []string, key string) ([]string, bool) {
+	if key == "" {
+		return keys, true
+	}
+	for _, k := range keys {
+		if k == key {
+			return keys, false
+		}
+	}
+	keys = append(keys, key)
+	if len(keys) > maxKeys {
+		keys = keys[len(keys)-maxKeys:]
+	}
+	return keys, true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// By default (COUNTER_WRITE=lock), the read-modify-write of an add is protected
+// by a mutex, which is only safe if a single process ever writes the bucket of
+// a node. With COUNTER_WRITE=cas, an add is a CompareAndSwap from the bucket
+// read, retried on conflict after a random backoff, so several processes (e.g.,
+// a lingering process after a restart) can safely share a bucket. The mutex is
+// still used to avoid conflicts between the adds of the same process. The
+// bucket is also only created at init if it doesn't exist yet, instead of
+// being reset.
+//
+// The contention is tracked with the number of CAS attempts, conflicts, and
+// adds that gave up after maxCASAttempts, returned by the stats message.
+
+// Write modes, selected with the COUNTER_WRITE environment variable.
+const (
+	lockWrites = "lock"
+	casWrites  = "cas"
+)
+
+const (
+	maxCASAttempts = 10
+	casBackoff     = 10 * time.Millisecond
+)
+
+func writesFromEnv() (string, error) {
+	switch writes := os.Getenv("COUNTER_WRITE"); writes {
+	case "":
+		return lockWrites, nil
+	case lockWrites, casWrites:
+		return writes, nil
+	default:
+		return "", fmt.Errorf("unknown write mode: %q", writes)
+	}
+}
+
+type casStats struct {
+	attempts  atomic.Int64
+	conflicts atomic.Int64
+	failures  atomic.Int64
+}
+
+// updateCAS applies a change with a CompareAndSwap.
+func (s *server) updateCAS(c change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < maxCASAttempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(casBackoff) * int64(i))))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		b, raw, err := s.readBucket(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		next, err := c(b)
+		if err == errUnchanged {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.cas.attempts.Add(1)
+		ctx, cancel = context.WithTimeout(context.Background(), defaultTimeout)
+		err = s.kv.CompareAndSwap(ctx, s.nodeID, raw, next, false)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return err
+		}
+		// Another process wrote the bucket in the meantime
+		s.cas.conflicts.Add(1)
+	}
+
+	s.cas.failures.Add(1)
+	log.Warnf("%s gave up a write after %d CAS conflicts", s.nodeID, maxCASAttempts)
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "too much contention on the bucket")
+}
+
+// createBucket creates the bucket of the node unless it already exists.
+func (s *server) createBucket(ctx context.Context) error {
+	initial := s.initialBucket()
+	err := s.kv.CompareAndSwap(ctx, s.nodeID, initial, initial, true)
+	if err != nil && maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		return err
+	}
+	return nil
+}
+
+func (s *server) statsHandler(msg maelstrom.Message) error {
+	return s.n.Reply(msg, map[string]any{
+		"type":          "stats_ok",
+		"cas_attempts":  s.cas.attempts.Load(),
+		"cas_conflicts": s.cas.conflicts.Load(),
+		"cas_failures":  s.cas.failures.Load(),
+	})
+}
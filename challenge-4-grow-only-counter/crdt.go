@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/crdt"
+)
+
+// In CRDT mode (COUNTER_MODE=crdt), the counter is a state-based PN-counter
+// instead of a value per node in seq-kv:
+//   - Each node keeps two G-counters, a vector with the total added by each
+//     node for the positive deltas (P) and one for the negative deltas (N), and
+//     only increments its own entries
+//   - Every gossipFrequency, a node sends the entries that changed to all the
+//     other nodes, which merge them by taking the element-wise max (so a gossip
+//     can be lost, duplicated or reordered)
+//   - A read is answered locally with sum(P) - sum(N)
+//
+// Splitting the deltas keeps each vector monotonic, which is what makes the
+// max a valid merge; a single vector would lose a decrement merged with an
+// older, higher value. With the grow-only workload, N simply stays empty.
+//
+// A read doesn't require any round trip anymore, but it can miss the values
+// added on the other nodes for up to gossipFrequency plus the network latency
+// (or for the duration of a partition).
+//
+// The PN-counter itself comes from the shared crdt package. Rather than its full
+// state, which grows with the number of nodes, a node only gossips the deltas
+// that a peer didn't acknowledge yet (crdt.DeltaLog): each add produces a
+// delta, numbered by a sequence, and a peer acknowledges the sequence number it
+// merged up to. A peer that is up to date isn't sent anything, and one that
+// lags more than maxDeltas behind (after a long partition) is sent the full
+// state.
+//
+// The deltas received from the other nodes are merged but not forwarded
+// (crdt.DeltaLog.Merge rather than Forward, unlike the grow-only set over a
+// tree), as each node sends its own to all the others. Forwarding would make
+// every node relay every delta to all its peers, so each gossip would carry
+// the entries of all the nodes: with 10 nodes, it sends about 50% more bytes,
+// more than gossiping the full state every round. But then, the values of a
+// node that dies while partitioned from some nodes would never reach them. So
+// every fullStateRounds gossip rounds, one other node (round-robin) is sent the
+// full state instead, which carries the values of all the nodes.
+
+const (
+	gossipFrequency = 200 * time.Millisecond
+	maxDeltas       = 1000
+	fullStateRounds = 10
+)
+
+type pncounter struct {
+	s *server
+
+	mu     sync.Mutex
+	deltas *crdt.DeltaLog[*crdt.PNCounter]
+	// Number of gossip rounds
+	rounds int
+	// Idempotency keys of the adds applied by this node
+	keys []string
+}
+
+func newPNCounter(s *server) *pncounter {
+	return &pncounter{
+		s:      s,
+		deltas: crdt.NewDeltaLog(crdt.NewPNCounter(), crdt.NewPNCounter, maxDeltas),
+	}
+}
+
+func (c *pncounter) handle(n *maelstrom.Node) {
+	n.Handle("add", c.addHandler)
+	n.Handle("read", c.readHandler)
+	n.Handle("counter_gossip", c.s.members.Wrap(c.gossipHandler))
+}
+
+func (c *pncounter) addHandler(msg maelstrom.Message) error {
+	var body map[string]any
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	delta := int(body["delta"].(float64))
+	key, _ := body["key"].(string)
+	c.mu.Lock()
+	keys, applied := applyKey(c.keys, key)
+	c.keys = keys
+	if applied {
+		c.deltas.Apply(c.deltas.State().AddDelta(c.s.nodeID, delta))
+	}
+	c.mu.Unlock()
+
+	return c.s.n.Reply(msg, map[string]any{
+		"type": "add_ok",
+	})
+}
+
+func (c *pncounter) readHandler(msg maelstrom.Message) error {
+	c.mu.Lock()
+	value := c.deltas.State().Value()
+	c.mu.Unlock()
+
+	return c.s.n.Reply(msg, map[string]any{
+		"type":  "read_ok",
+		"value": value,
+	})
+}
+
+// gossip sends to each other node the deltas it didn't acknowledge, or the full
+// state every fullStateRounds rounds.
+func (c *pncounter) gossip() {
+	nodeIDs := c.s.n.NodeIDs()
+	c.mu.Lock()
+	c.rounds++
+	full := ""
+	if c.rounds%fullStateRounds == 0 && len(nodeIDs) > 1 {
+		full = nodeIDs[(c.rounds/fullStateRounds)%len(nodeIDs)]
+	}
+	c.mu.Unlock()
+
+	for _, dst := range nodeIDs {
+		if dst == c.s.nodeID {
+			continue
+		}
+		c.mu.Lock()
+		delta, seq, ok := c.deltas.Pending(dst)
+		if dst == full {
+			delta, seq, ok = c.deltas.State().Copy(), c.deltas.Seq(), true
+		}
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		dst := dst
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), gossipFrequency)
+			defer cancel()
+			if _, err := c.s.n.SyncRPC(ctx, dst, c.s.members.Piggyback(map[string]any{
+				"type": "counter_gossip",
+				"p":    delta.P,
+				"n":    delta.N,
+			})); err != nil {
+				log.Warnf("failed to gossip to %s: %v", dst, err)
+				return
+			}
+			c.mu.Lock()
+			c.deltas.Ack(dst, seq)
+			c.mu.Unlock()
+		}()
+	}
+}
+
+func (c *pncounter) gossipHandler(msg maelstrom.Message) error {
+	var body crdt.PNCounter
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.deltas.Merge(&body)
+	c.mu.Unlock()
+
+	return c.s.n.Reply(msg, map[string]any{
+		"type": "counter_gossip_ok",
+	})
+}
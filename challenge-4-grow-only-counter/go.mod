@@ -5,6 +5,15 @@ go 1.20
 require (
 	github.com/jepsen-io/maelstrom/demo/go v0.0.0-20230113211434-22f433519054
 	github.com/sirupsen/logrus v1.9.0
+	github.com/teivah/gossip-glomers/crdt v0.0.0
+	github.com/teivah/gossip-glomers/harness v0.0.0
+	github.com/teivah/gossip-glomers/membership v0.0.0
 )
 
 require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+
+replace (
+	github.com/teivah/gossip-glomers/crdt => ../crdt
+	github.com/teivah/gossip-glomers/harness => ../harness
+	github.com/teivah/gossip-glomers/membership => ../membership
+)
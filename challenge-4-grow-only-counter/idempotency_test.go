@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teivah/gossip-glomers/harness"
+)
+
+// bin is the node binary, built once for all the tests.
+var bin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "counter")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	bin = filepath.Join(dir, "bin")
+	if err := harness.Build(bin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type idempotentAdd struct {
+	delta int
+	key   string
+}
+
+// Each add is sent twice, the ones without a key being counted twice
+var idempotentAdds = []idempotentAdd{{5, "k1"}, {3, "k2"}, {1, ""}}
+
+const idempotentTotal = 5 + 3 + 2*1
+
+// TestIdempotency sends duplicate adds (a client retrying with the same key)
+// concurrently to a single node and checks that they are only counted once. In
+// kv mode, it also checks that the keys are stored in the bucket of the node
+// along with its value. With CAS writes, the node is then restarted and the
+// adds retried once more, which checks that the keys survive the process (with
+// lock writes, the bucket is reset at init).
+func TestIdempotency(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     []string
+		restart bool
+	}{
+		{"kv mode, lock writes", []string{"COUNTER_WRITE=lock"}, false},
+		{"kv mode, CAS writes", []string{"COUNTER_WRITE=cas"}, true},
+		{"crdt mode", []string{"COUNTER_MODE=crdt"}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := harness.NewCluster(0)
+			t.Cleanup(c.Close)
+			if err := c.Start(bin, "n0", tt.env...); err != nil {
+				t.Fatal(err)
+			}
+			if err := sendAdds(c, 2, idempotentTotal); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.restart {
+				return
+			}
+
+			c.Stop("n0")
+			if err := c.Start(bin, "n0", tt.env...); err != nil {
+				t.Fatal(err)
+			}
+			// Only the adds without a key are counted again
+			if err := sendAdds(c, 1, idempotentTotal+1); err != nil {
+				t.Fatalf("after the restart: %v", err)
+			}
+		})
+	}
+}
+
+// sendAdds initializes the node, sends each add copies times concurrently,
+// and checks the counter against want.
+func sendAdds(c *harness.Cluster, copies, want int) error {
+	if err := c.Init([]string{"n0"}, nil); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(idempotentAdds)*copies)
+	for _, a := range idempotentAdds {
+		for i := 0; i < copies; i++ {
+			body := map[string]any{"type": "add", "delta": a.delta}
+			if a.key != "" {
+				body["key"] = a.key
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := c.RPC("n0", body, 5*time.Second); err != nil {
+					errs <- fmt.Errorf("add: %v", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	res, err := c.RPC("n0", map[string]any{"type": "read"}, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("read: %v", err)
+	}
+	if value, _ := res["value"].(float64); int(value) != want {
+		return fmt.Errorf("read %v, expected %d", res["value"], want)
+	}
+
+	// In kv mode, the keys are stored along with the value
+	var b bucket
+	exists, err := c.Store("seq-kv").Get("n0", &b)
+	if err != nil {
+		return fmt.Errorf("bucket: %v", err)
+	}
+	if !exists {
+		return nil
+	}
+	if b.Value != want {
+		return fmt.Errorf("bucket value %d, expected %d", b.Value, want)
+	}
+	for _, a := range idempotentAdds {
+		if a.key != "" && !contains(b.Keys, a.key) {
+			return fmt.Errorf("key %s missing from the bucket %+v", a.key, b)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -10,10 +10,17 @@ import (
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 	log "github.com/sirupsen/logrus"
+	"github.com/teivah/gossip-glomers/membership"
 )
 
 const defaultTimeout = time.Second
 
+// Counter modes, selected with the COUNTER_MODE environment variable.
+const (
+	kvMode   = "kv"
+	crdtMode = "crdt"
+)
+
 func init() {
 	f, err := os.OpenFile("/tmp/maelstrom.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -23,14 +30,55 @@ func init() {
 }
 
 func main() {
+	read, err := readPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	writes, err := writesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bound, err := boundFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	n := maelstrom.NewNode()
 	kv := maelstrom.NewSeqKV(n)
-	s := &server{n: n, kv: kv, cache: make(map[string]int)}
+	members := membership.New(n, membership.ConfigFromEnv())
+	s := &server{n: n, kv: kv, cache: make(map[string]cached), read: read, writes: writes, bound: bound, members: members}
 
 	n.Handle("init", s.initHandler)
-	n.Handle("add", s.addHandler)
-	n.Handle("read", s.readHandler)
-	n.Handle("local", s.localHandler)
+
+	switch mode := os.Getenv("COUNTER_MODE"); mode {
+	case kvMode, "":
+		n.Handle("add", s.addHandler)
+		n.Handle("read", s.readHandler)
+		n.Handle("local", members.Wrap(s.localHandler))
+		n.Handle("stats", s.statsHandler)
+		if bound.enabled {
+			n.Handle("rights_request", members.Wrap(s.rightsHandler))
+		}
+	case crdtMode:
+		if read.consistency != available {
+			log.Fatalf("COUNTER_CONSISTENCY isn't supported in %s mode", mode)
+		}
+		if bound.enabled {
+			log.Fatalf("COUNTER_FLOOR isn't supported in %s mode", mode)
+		}
+		s.crdt = newPNCounter(s)
+		s.crdt.handle(n)
+		go func() {
+			for {
+				select {
+				case <-time.After(gossipFrequency):
+					s.crdt.gossip()
+				}
+			}
+		}()
+	default:
+		log.Fatalf("unknown counter mode: %q", mode)
+	}
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -43,7 +91,18 @@ type server struct {
 	id     int
 	kv     *maelstrom.KV
 	mu     sync.Mutex
-	cache  map[string]int
+	writes string
+	cas    casStats
+	bound  bound
+
+	cacheMu sync.Mutex
+	cache   map[string]cached
+	read    readPolicy
+
+	members *membership.Membership
+
+	// Only set in CRDT mode
+	crdt *pncounter
 }
 
 func (s *server) initHandler(_ maelstrom.Message) error {
@@ -54,11 +113,18 @@ func (s *server) initHandler(_ maelstrom.Message) error {
 		return err
 	}
 	s.id = id
+	s.members.Start()
 
 	defer s.mu.Unlock()
+	if s.crdt != nil {
+		return nil
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
-	if err := s.kv.Write(ctx, s.nodeID, 0); err != nil {
+	if s.writes == casWrites {
+		return s.createBucket(ctx)
+	}
+	if err := s.kv.Write(ctx, s.nodeID, s.initialBucket()); err != nil {
 		log.Error(err)
 		return err
 	}
@@ -72,21 +138,13 @@ func (s *server) addHandler(msg maelstrom.Message) error {
 	}
 
 	delta := int(body["delta"].(float64))
+	key, _ := body["key"].(string)
 
-	s.mu.Lock()
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
-	sum, err := s.kv.ReadInt(ctx, s.nodeID)
-	if err != nil {
-		return err
+	add := s.update
+	if s.bound.enabled {
+		add = s.updateBounded
 	}
-
-	ctx, cancel2 := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel2()
-	err = s.kv.Write(ctx, s.nodeID, sum+delta)
-	s.mu.Unlock()
-	if err != nil {
-		log.Error(err)
+	if err := add(s.addChange(delta, key)); err != nil {
 		return err
 	}
 
@@ -95,62 +153,53 @@ func (s *server) addHandler(msg maelstrom.Message) error {
 	})
 }
 
-func (s *server) readHandler(msg maelstrom.Message) error {
-	sum := 0
-	for _, nodeID := range s.n.NodeIDs() {
-		if nodeID == s.nodeID {
-			ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-			v, err := s.kv.ReadInt(ctx, s.nodeID)
-			cancel()
-			if err != nil {
-				log.Warnf("failed to read %s: %v", s.nodeID, err)
-				// Default to local cache
-				sum += s.cache[nodeID]
-				continue
-			}
-
-			sum += v
-			s.cache[nodeID] = v
-		} else {
-			ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-			res, err := s.n.SyncRPC(ctx, nodeID, map[string]any{
-				"type": "local",
-			})
-			cancel()
-			if err != nil {
-				log.Warnf("failed to call local endpoint %s from %s: %v", nodeID, s.nodeID, err)
-				// Default to local cache
-				sum += s.cache[nodeID]
-				continue
-			}
-
-			var body map[string]any
-			if err := json.Unmarshal(res.Body, &body); err != nil {
-				return err
-			}
+// update applies a change to the bucket of the node, depending on the write
+// mode.
+func (s *server) update(c change) error {
+	if s.writes == casWrites {
+		return s.updateCAS(c)
+	}
+	return s.updateLocked(c)
+}
 
-			v := int(body["value"].(float64))
-			sum += v
-			s.cache[nodeID] = v
-		}
+// updateLocked applies a change with a read-modify-write protected by the
+// mutex.
+func (s *server) updateLocked(c change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	b, _, err := s.readBucket(ctx)
+	if err != nil {
+		return err
 	}
 
-	return s.n.Reply(msg, map[string]any{
-		"type":  "read_ok",
-		"value": sum,
-	})
+	b, err = c(b)
+	if err == errUnchanged {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel2 := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel2()
+	if err := s.kv.Write(ctx, s.nodeID, b); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
 }
 
 func (s *server) localHandler(msg maelstrom.Message) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
-	v, err := s.kv.ReadInt(ctx, s.nodeID)
+	b, _, err := s.readBucket(ctx)
 	if err != nil {
 		return err
 	}
 
 	return s.n.Reply(msg, map[string]any{
 		"type":  "local_ok",
-		"value": v,
+		"value": b.Value,
 	})
 }
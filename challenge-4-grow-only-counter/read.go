@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// In kv mode, a read asks every node for its value concurrently (the node
+// itself reads seq-kv), with a single deadline for the whole read
+// (COUNTER_READ_DEADLINE, 1s by default). COUNTER_READ_WAIT sets how many
+// nodes have to answer before replying:
+//   - all (default): all the nodes, or until the deadline
+//   - quorum: a majority of the nodes
+//   - any: a single node
+//
+// When a node doesn't answer in time (or at all, if it's not live), what
+// happens depends on COUNTER_CONSISTENCY:
+//   - available (default): its cached value is used
+//   - strict: the read fails with TemporarilyUnavailable, so the answers of all
+//     the nodes are required
+//   - bounded-staleness: its cached value is used only if it was refreshed less
+//     than COUNTER_MAX_STALENESS ago (1s by default), otherwise the read fails
+//
+// The late answers still refresh the cache.
+
+// Read waits, selected with the COUNTER_READ_WAIT environment variable.
+const (
+	waitAll    = "all"
+	waitQuorum = "quorum"
+	waitAny    = "any"
+)
+
+// Consistency modes, selected with the COUNTER_CONSISTENCY environment
+// variable.
+const (
+	available        = "available"
+	strict           = "strict"
+	boundedStaleness = "bounded-staleness"
+)
+
+const defaultMaxStaleness = time.Second
+
+type readPolicy struct {
+	wait         string
+	deadline     time.Duration
+	consistency  string
+	maxStaleness time.Duration
+}
+
+func readPolicyFromEnv() (readPolicy, error) {
+	p := readPolicy{
+		wait:         waitAll,
+		deadline:     defaultTimeout,
+		consistency:  available,
+		maxStaleness: defaultMaxStaleness,
+	}
+	switch wait := os.Getenv("COUNTER_READ_WAIT"); wait {
+	case "":
+	case waitAll, waitQuorum, waitAny:
+		p.wait = wait
+	default:
+		return readPolicy{}, fmt.Errorf("unknown read wait: %q", wait)
+	}
+	if v := os.Getenv("COUNTER_READ_DEADLINE"); v != "" {
+		deadline, err := time.ParseDuration(v)
+		if err != nil {
+			return readPolicy{}, fmt.Errorf("invalid COUNTER_READ_DEADLINE: %w", err)
+		}
+		p.deadline = deadline
+	}
+	switch consistency := os.Getenv("COUNTER_CONSISTENCY"); consistency {
+	case "":
+	case available, strict, boundedStaleness:
+		p.consistency = consistency
+	default:
+		return readPolicy{}, fmt.Errorf("unknown consistency mode: %q", consistency)
+	}
+	if v := os.Getenv("COUNTER_MAX_STALENESS"); v != "" {
+		maxStaleness, err := time.ParseDuration(v)
+		if err != nil {
+			return readPolicy{}, fmt.Errorf("invalid COUNTER_MAX_STALENESS: %w", err)
+		}
+		p.maxStaleness = maxStaleness
+	}
+	if p.consistency == strict && p.wait != waitAll {
+		return readPolicy{}, fmt.Errorf("the %s consistency requires COUNTER_READ_WAIT=%s", strict, waitAll)
+	}
+	return p, nil
+}
+
+// answers returns the number of answers to wait for among n nodes.
+func (p readPolicy) answers(n int) int {
+	switch p.wait {
+	case waitQuorum:
+		return n/2 + 1
+	case waitAny:
+		return 1
+	default:
+		return n
+	}
+}
+
+// fallback returns the cached value of a node that didn't answer. It must be
+// called while holding the cache lock.
+func (s *server) fallback(nodeID string) (int, error) {
+	c, exists := s.cache[nodeID]
+	switch s.read.consistency {
+	case strict:
+		return 0, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable,
+			fmt.Sprintf("%s didn't answer", nodeID))
+	case boundedStaleness:
+		if !exists || time.Since(c.at) > s.read.maxStaleness {
+			return 0, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable,
+				fmt.Sprintf("%s didn't answer and its cached value is too stale", nodeID))
+		}
+	}
+	return c.value, nil
+}
+
+// cached is the last known value of a node.
+type cached struct {
+	value int
+	at    time.Time
+}
+
+type nodeValue struct {
+	nodeID string
+	value  int
+	err    error
+}
+
+func (s *server) readHandler(msg maelstrom.Message) error {
+	nodeIDs := s.n.NodeIDs()
+	deadline := time.After(s.read.deadline)
+	// Buffered so that the late answers don't block
+	values := make(chan nodeValue, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		nodeID := nodeID
+		if nodeID != s.nodeID && !s.members.IsLive(nodeID) {
+			// No need to wait for a suspected or dead node
+			values <- nodeValue{nodeID: nodeID, err: fmt.Errorf("%s isn't live", nodeID)}
+			continue
+		}
+		go func() {
+			v, err := s.readNode(nodeID)
+			if err == nil {
+				s.cacheMu.Lock()
+				s.cache[nodeID] = cached{value: v, at: time.Now()}
+				s.cacheMu.Unlock()
+			}
+			values <- nodeValue{nodeID: nodeID, value: v, err: err}
+		}()
+	}
+
+	answered := make(map[string]int, len(nodeIDs))
+	needed := s.read.answers(len(nodeIDs))
+loop:
+	for received := 0; received < len(nodeIDs) && len(answered) < needed; received++ {
+		select {
+		case v := <-values:
+			if v.err != nil {
+				log.Warnf("failed to read the value of %s from %s: %v", v.nodeID, s.nodeID, v.err)
+				continue
+			}
+			answered[v.nodeID] = v.value
+		case <-deadline:
+			break loop
+		}
+	}
+
+	sum := 0
+	s.cacheMu.Lock()
+	for _, nodeID := range nodeIDs {
+		if v, exists := answered[nodeID]; exists {
+			sum += v
+			continue
+		}
+		v, err := s.fallback(nodeID)
+		if err != nil {
+			s.cacheMu.Unlock()
+			return err
+		}
+		sum += v
+	}
+	s.cacheMu.Unlock()
+
+	return s.n.Reply(msg, map[string]any{
+		"type":  "read_ok",
+		"value": sum,
+	})
+}
+
+// readNode returns the value of a node, bounded by the read deadline.
+func (s *server) readNode(nodeID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.read.deadline)
+	defer cancel()
+
+	if nodeID == s.nodeID {
+		b, _, err := s.readBucket(ctx)
+		return b.Value, err
+	}
+
+	res, err := s.n.SyncRPC(ctx, nodeID, s.members.Piggyback(map[string]any{
+		"type": "local",
+	}))
+	if err != nil {
+		return 0, err
+	}
+	var body map[string]any
+	if err := json.Unmarshal(res.Body, &body); err != nil {
+		return 0, err
+	}
+	return int(body["value"].(float64)), nil
+}
@@ -0,0 +1,9 @@
+#!/bin/bash
+# Runs the pn-counter workload with a floor of 0. The decrements rejected for
+# lack of rights are definite failures, so the checker ignores them.
+
+cwd=$(pwd)
+go build -o bin
+cd $MAELSTROM_PATH
+COUNTER_FLOOR=0 ./maelstrom test -w pn-counter --bin $cwd/bin --node-count 3 --rate 100 --time-limit 20 --nemesis partition
+cd $cwd
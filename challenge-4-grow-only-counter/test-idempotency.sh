@@ -0,0 +1,10 @@
+#!/bin/bash
+# Sends duplicate adds (a client retrying with the same key) to a single node
+# and checks that they are only counted once (TestIdempotency). The test runs
+# the node with the harness module, which emulates seq-kv, so it doesn't
+# require Maelstrom. In kv mode, it runs with both write modes and also checks
+# that the keys are persisted in the bucket of the node; with CAS writes, the
+# node is restarted and the adds retried, as the bucket isn't reset at init.
+# The CRDT mode is checked as well.
+
+go test -count=1 -v -run 'TestIdempotency$' .
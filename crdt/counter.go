@@ -0,0 +1,109 @@
+package crdt
+
+// GCounter is a grow-only counter: the total added by each replica.
+type GCounter map[string]int
+
+func NewGCounter() GCounter {
+	return make(GCounter)
+}
+
+// Inc adds n on behalf of a replica. A negative n is ignored.
+func (g GCounter) Inc(node string, n int) {
+	if n <= 0 {
+		// Zero entries would make otherwise equal counters differ
+		return
+	}
+	g[node] += n
+}
+
+// IncDelta adds n on behalf of a replica and returns the delta-mutator: the
+// updated entry.
+func (g GCounter) IncDelta(node string, n int) GCounter {
+	g.Inc(node, n)
+	res := make(GCounter)
+	if v, exists := g[node]; exists {
+		res[node] = v
+	}
+	return res
+}
+
+func (g GCounter) Value() int {
+	sum := 0
+	for _, v := range g {
+		sum += v
+	}
+	return sum
+}
+
+// Merge takes the element-wise max of two counters.
+func (g GCounter) Merge(other GCounter) {
+	for node, v := range other {
+		if v > g[node] {
+			g[node] = v
+		}
+	}
+}
+
+// Delta returns the entries greater than the ones of since.
+func (g GCounter) Delta(since GCounter) GCounter {
+	res := make(GCounter)
+	for node, v := range g {
+		if v > since[node] {
+			res[node] = v
+		}
+	}
+	return res
+}
+
+func (g GCounter) Copy() GCounter {
+	res := make(GCounter, len(g))
+	for node, v := range g {
+		res[node] = v
+	}
+	return res
+}
+
+// PNCounter is a counter supporting negative deltas: a G-counter for the
+// positive deltas (P) and one for the negative ones (N).
+type PNCounter struct {
+	P GCounter `json:"p"`
+	N GCounter `json:"n"`
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: NewGCounter(), N: NewGCounter()}
+}
+
+// Add adds delta on behalf of a replica.
+func (c *PNCounter) Add(node string, delta int) {
+	if delta >= 0 {
+		c.P.Inc(node, delta)
+	} else {
+		c.N.Inc(node, -delta)
+	}
+}
+
+// AddDelta adds delta on behalf of a replica and returns the delta-mutator.
+func (c *PNCounter) AddDelta(node string, delta int) *PNCounter {
+	if delta >= 0 {
+		return &PNCounter{P: c.P.IncDelta(node, delta), N: NewGCounter()}
+	}
+	return &PNCounter{P: NewGCounter(), N: c.N.IncDelta(node, -delta)}
+}
+
+func (c *PNCounter) Value() int {
+	return c.P.Value() - c.N.Value()
+}
+
+func (c *PNCounter) Merge(other *PNCounter) {
+	c.P.Merge(other.P)
+	c.N.Merge(other.N)
+}
+
+func (c *PNCounter) Delta(since *PNCounter) *PNCounter {
+	return &PNCounter{P: c.P.Delta(since.P), N: c.N.Delta(since.N)}
+}
+
+func (c *PNCounter) Copy() *PNCounter {
+	return &PNCounter{P: c.P.Copy(), N: c.N.Copy()}
+}
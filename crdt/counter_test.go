@@ -0,0 +1,24 @@
+package crdt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestGCounter(t *testing.T) {
+	checkProperties(t, spec[GCounter]{
+		new: NewGCounter,
+		update: func(rng *rand.Rand, node string, x GCounter) {
+			x.Inc(node, rng.Intn(5))
+		},
+	})
+}
+
+func TestPNCounter(t *testing.T) {
+	checkProperties(t, spec[*PNCounter]{
+		new: NewPNCounter,
+		update: func(rng *rand.Rand, node string, x *PNCounter) {
+			x.Add(node, rng.Intn(9)-4)
+		},
+	})
+}
@@ -0,0 +1,98 @@
+// Package crdt provides state-based CRDTs (conflict-free replicated data
+// types): each replica updates its own state, and the replicas exchange their
+// states and merge them. The merge of every type is commutative, associative and
+// idempotent, so a state can be gossiped in any order, duplicated or lost: the
+// replicas that merged the same updates converge.
+//
+// Every type also supports delta extraction: x.Delta(since) returns the part of
+// x that since doesn't cover, so that merging the delta into since is the same
+// as merging x into since. A replica that knows the state of a peer can then
+// send a delta instead of its full state.
+//
+// The types are not safe for concurrent use.
+package crdt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mergeable is a CRDT that can be nested in an ORMap.
+type Mergeable[T any] interface {
+	Merge(other T)
+	Delta(since T) T
+	Copy() T
+}
+
+// Dot identifies an update: the replica that made it and its sequence number on
+// this replica.
+type Dot struct {
+	Node string `json:"node"`
+	Seq  int    `json:"seq"`
+}
+
+// VClock is a vector clock: the number of updates made by each replica.
+type VClock map[string]int
+
+// Merge takes the element-wise max of two clocks.
+func (v VClock) Merge(other VClock) {
+	for node, n := range other {
+		if n > v[node] {
+			v[node] = n
+		}
+	}
+}
+
+// Descends returns whether v has seen all the updates of other.
+func (v VClock) Descends(other VClock) bool {
+	for node, n := range other {
+		if v[node] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal returns whether two clocks are equal, the missing entries being zeros.
+func (v VClock) Equal(other VClock) bool {
+	return v.Descends(other) && other.Descends(v)
+}
+
+// Delta returns the entries greater than the ones of since.
+func (v VClock) Delta(since VClock) VClock {
+	res := make(VClock)
+	for node, n := range v {
+		if n > since[node] {
+			res[node] = n
+		}
+	}
+	return res
+}
+
+func (v VClock) Copy() VClock {
+	res := make(VClock, len(v))
+	for node, n := range v {
+		res[node] = n
+	}
+	return res
+}
+
+// String returns a canonical representation of the clock.
+func (v VClock) String() string {
+	nodes := make([]string, 0, len(v))
+	for node, n := range v {
+		if n != 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	var sb strings.Builder
+	for i, node := range nodes {
+		if i != 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s:%d", node, v[node])
+	}
+	return sb.String()
+}
@@ -0,0 +1,102 @@
+package crdt
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+var nodes = []string{"n0", "n1", "n2"}
+
+// spec describes how to get random states of a CRDT type T.
+type spec[T Mergeable[T]] struct {
+	new func() T
+	// update applies a random update to x on behalf of node
+	update func(rng *rand.Rand, node string, x T)
+}
+
+// states returns the states of the replicas after random updates and merges,
+// derived from seed so that quick reports a failure by its seed.
+func (sp spec[T]) states(seed int64) (T, T, T) {
+	rng := rand.New(rand.NewSource(seed))
+	replicas := make([]T, len(nodes))
+	for i := range replicas {
+		replicas[i] = sp.new()
+	}
+	for i := rng.Intn(50); i > 0; i-- {
+		r := rng.Intn(len(nodes))
+		if rng.Intn(4) == 0 {
+			replicas[r].Merge(replicas[rng.Intn(len(nodes))])
+			continue
+		}
+		sp.update(rng, nodes[r], replicas[r])
+	}
+	return replicas[0], replicas[1], replicas[2]
+}
+
+// merged returns x ⊔ other without modifying x.
+func merged[T Mergeable[T]](x, other T) T {
+	res := x.Copy()
+	res.Merge(other)
+	return res
+}
+
+// checkProperties checks the merge properties on random states a, b and c:
+//   - commutativity: a ⊔ b = b ⊔ a
+//   - associativity: (a ⊔ b) ⊔ c = a ⊔ (b ⊔ c)
+//   - idempotence: a ⊔ a = a
+//   - delta extraction: b ⊔ a.Delta(b) = b ⊔ a
+//   - JSON serialization: decode(encode(a)) = a
+func checkProperties[T Mergeable[T]](t *testing.T, sp spec[T]) {
+	properties := []struct {
+		name  string
+		holds func(a, b, c T) bool
+	}{
+		{"commutativity", func(a, b, _ T) bool {
+			return reflect.DeepEqual(merged(a, b), merged(b, a))
+		}},
+		{"associativity", func(a, b, c T) bool {
+			return reflect.DeepEqual(merged(merged(a, b), c), merged(a, merged(b, c)))
+		}},
+		{"idempotence", func(a, _, _ T) bool {
+			return reflect.DeepEqual(merged(a, a), a)
+		}},
+		{"delta extraction", func(a, b, _ T) bool {
+			return reflect.DeepEqual(merged(b, a.Delta(b)), merged(b, a))
+		}},
+		{"JSON serialization", func(a, _, _ T) bool {
+			decoded := sp.new()
+			data, err := json.Marshal(a)
+			if err != nil || json.Unmarshal(data, &decoded) != nil {
+				return false
+			}
+			return reflect.DeepEqual(decoded, a)
+		}},
+	}
+	for _, p := range properties {
+		p := p
+		t.Run(p.name, func(t *testing.T) {
+			err := quick.Check(func(seed int64) bool {
+				a, b, c := sp.states(seed)
+				if p.holds(a, b, c) {
+					return true
+				}
+				t.Logf("a=%s b=%s c=%s", encode(a), encode(b), encode(c))
+				return false
+			}, &quick.Config{MaxCount: 1000})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func encode(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return []byte(err.Error())
+	}
+	return data
+}
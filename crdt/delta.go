@@ -0,0 +1,128 @@
+package crdt
+
+import "reflect"
+
+// DeltaLog lets a replica send each peer only the deltas that the peer didn't
+// acknowledge, instead of its full state (delta-state CRDTs):
+//   - The deltas applied to the state are buffered and numbered by a sequence
+//   - A peer acknowledges the sequence number up to which it merged the deltas
+//   - Pending returns the join of the deltas a peer didn't acknowledge
+//
+// When the replicas don't all send their deltas to each other (e.g., over a
+// tree), the deltas received from a peer are forwarded to the other peers, but
+// not back to that peer.
+//
+// The deltas acknowledged by all the peers that ever acknowledged one are
+// dropped, and at most maxDeltas deltas are kept. A peer lagging further
+// behind (after a long partition) or that never acknowledged anything is sent
+// the full state instead, which is also a valid delta.
+type DeltaLog[T Mergeable[T]] struct {
+	state     T
+	empty     func() T
+	maxDeltas int
+	// Sequence number of deltas[0]
+	first  int
+	deltas []T
+	// Peer each delta was received from, empty for the local ones
+	origins []string
+	acked   map[string]int
+}
+
+// NewDeltaLog returns a log over state. empty returns the bottom state, used to
+// join the deltas.
+func NewDeltaLog[T Mergeable[T]](state T, empty func() T, maxDeltas int) *DeltaLog[T] {
+	return &DeltaLog[T]{
+		state:     state,
+		empty:     empty,
+		maxDeltas: maxDeltas,
+		first:     1,
+		acked:     make(map[string]int),
+	}
+}
+
+// State returns the state. It must not be modified directly.
+func (l *DeltaLog[T]) State() T {
+	return l.state
+}
+
+// Seq returns the sequence number of the last delta.
+func (l *DeltaLog[T]) Seq() int {
+	return l.first + len(l.deltas) - 1
+}
+
+// Apply merges a delta into the state and buffers it so that it's sent to the
+// peers. The delta must not be modified afterwards.
+func (l *DeltaLog[T]) Apply(delta T) {
+	l.Forward("", delta)
+}
+
+// Forward merges a delta received from a peer into the state and buffers the
+// part of it that was new, so that it's sent to the other peers. Otherwise, the
+// replicas would keep forwarding the same deltas to each other. The delta must
+// not be modified afterwards.
+func (l *DeltaLog[T]) Forward(src string, delta T) {
+	if src != "" {
+		delta = delta.Delta(l.state)
+		if reflect.DeepEqual(delta, l.empty()) {
+			return
+		}
+	}
+	l.state.Merge(delta)
+	l.deltas = append(l.deltas, delta)
+	l.origins = append(l.origins, src)
+	if len(l.deltas) > l.maxDeltas {
+		l.drop()
+	}
+}
+
+// drop drops the oldest delta.
+func (l *DeltaLog[T]) drop() {
+	l.deltas = l.deltas[1:]
+	l.origins = l.origins[1:]
+	l.first++
+}
+
+// Merge merges a delta received from a peer into the state without buffering
+// it, for when every replica sends its deltas to all the others.
+func (l *DeltaLog[T]) Merge(delta T) {
+	l.state.Merge(delta)
+}
+
+// Pending returns what to send to a peer, along with the sequence number to
+// acknowledge, or false if the peer is up to date.
+func (l *DeltaLog[T]) Pending(peer string) (T, int, bool) {
+	seq := l.Seq()
+	acked, exists := l.acked[peer]
+	if exists && acked >= seq {
+		var zero T
+		return zero, seq, false
+	}
+	if !exists || acked+1 < l.first {
+		return l.state.Copy(), seq, true
+	}
+	delta := l.empty()
+	for i := acked + 1 - l.first; i < len(l.deltas); i++ {
+		if l.origins[i] != peer {
+			delta.Merge(l.deltas[i])
+		}
+	}
+	return delta, seq, true
+}
+
+// Ack records that a peer merged the deltas up to seq.
+func (l *DeltaLog[T]) Ack(peer string, seq int) {
+	if acked, exists := l.acked[peer]; exists && acked >= seq {
+		return
+	}
+	l.acked[peer] = seq
+
+	min := seq
+	for _, acked := range l.acked {
+		if acked < min {
+			min = acked
+		}
+	}
+	for len(l.deltas) > 0 && l.first <= min {
+		l.drop()
+	}
+}
@@ -0,0 +1,93 @@
+package crdt
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// TestDeltaLog makes PN-counter replicas gossip their deltas through lossy
+// links, then through reliable ones until they are all up to date, and checks
+// that they converge, both when all the replicas gossip with each other and
+// when they form a line and forward the deltas they receive. maxDeltas is small
+// so that the full state fallback is exercised.
+func TestDeltaLog(t *testing.T) {
+	for _, forward := range []bool{false, true} {
+		forward := forward
+		name := "merge"
+		if forward {
+			name = "forward"
+		}
+		t.Run(name, func(t *testing.T) {
+			err := quick.Check(func(seed int64) bool {
+				return converges(t, rand.New(rand.NewSource(seed)), forward)
+			}, &quick.Config{MaxCount: 1000})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func converges(t *testing.T, rng *rand.Rand, forward bool) bool {
+	linked := func(src, dst int) bool {
+		if forward {
+			return src-dst == 1 || dst-src == 1
+		}
+		return src != dst
+	}
+	logs := make([]*DeltaLog[*PNCounter], len(nodes))
+	for i := range logs {
+		logs[i] = NewDeltaLog(NewPNCounter(), NewPNCounter, 4)
+	}
+	expected := 0
+	gossip := func(src, dst int, lossy bool) bool {
+		delta, seq, ok := logs[src].Pending(nodes[dst])
+		if !ok || (lossy && rng.Intn(3) == 0) {
+			return false
+		}
+		if forward {
+			logs[dst].Forward(nodes[src], delta)
+		} else {
+			logs[dst].Merge(delta)
+		}
+		if !lossy || rng.Intn(3) != 0 {
+			logs[src].Ack(nodes[dst], seq)
+		}
+		return true
+	}
+
+	for step := rng.Intn(100); step > 0; step-- {
+		src, dst := rng.Intn(len(nodes)), rng.Intn(len(nodes))
+		if rng.Intn(2) == 0 {
+			delta := rng.Intn(9) - 4
+			expected += delta
+			logs[src].Apply(logs[src].State().AddDelta(nodes[src], delta))
+		} else if linked(src, dst) {
+			gossip(src, dst, true)
+		}
+	}
+	for sent := true; sent; {
+		sent = false
+		for src := range logs {
+			for dst := range logs {
+				if linked(src, dst) && gossip(src, dst, false) {
+					sent = true
+				}
+			}
+		}
+	}
+
+	for i, l := range logs {
+		if l.State().Value() != expected {
+			t.Logf("%s has %d, expected %d: %s", nodes[i], l.State().Value(), expected, encode(l.State()))
+			return false
+		}
+		if !reflect.DeepEqual(l.State(), logs[0].State()) {
+			t.Logf("%s and %s diverge: %s %s", nodes[i], nodes[0], encode(l.State()), encode(logs[0].State()))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,3 @@
+module github.com/teivah/gossip-glomers/crdt
+
+go 1.20
@@ -0,0 +1,116 @@
+package crdt
+
+import "encoding/json"
+
+// ORMap is a map of CRDTs whose keys form an OR-set: a key added concurrently
+// to its removal stays in the map. The values of the removed keys are kept, so
+// a key added back gets its previous value merged with the new one.
+type ORMap[K comparable, V Mergeable[V]] struct {
+	keys   *ORSet[K]
+	values map[K]V
+}
+
+func NewORMap[K comparable, V Mergeable[V]]() *ORMap[K, V] {
+	return &ORMap[K, V]{
+		keys:   NewORSet[K](),
+		values: make(map[K]V),
+	}
+}
+
+// Put adds a key on behalf of a replica and merges v into its value.
+func (m *ORMap[K, V]) Put(node string, key K, v V) {
+	m.keys.Add(node, key)
+	m.mergeValue(key, v)
+}
+
+func (m *ORMap[K, V]) mergeValue(key K, v V) {
+	if cur, exists := m.values[key]; exists {
+		cur.Merge(v)
+		return
+	}
+	m.values[key] = v.Copy()
+}
+
+// Get returns the value of a key.
+func (m *ORMap[K, V]) Get(key K) (V, bool) {
+	if !m.keys.Contains(key) {
+		var zero V
+		return zero, false
+	}
+	return m.values[key], true
+}
+
+// Remove removes a key, as observed by this replica.
+func (m *ORMap[K, V]) Remove(key K) {
+	m.keys.Remove(key)
+}
+
+// Keys returns the keys of the map, in no particular order.
+func (m *ORMap[K, V]) Keys() []K {
+	return m.keys.Elements()
+}
+
+func (m *ORMap[K, V]) Merge(other *ORMap[K, V]) {
+	m.keys.Merge(other.keys)
+	for key, v := range other.values {
+		m.mergeValue(key, v)
+	}
+}
+
+// Delta returns the keys that since doesn't have, and the deltas of the values.
+func (m *ORMap[K, V]) Delta(since *ORMap[K, V]) *ORMap[K, V] {
+	res := &ORMap[K, V]{
+		keys:   m.keys.Delta(since.keys),
+		values: make(map[K]V),
+	}
+	for key, v := range m.values {
+		if cur, exists := since.values[key]; exists {
+			res.values[key] = v.Delta(cur)
+		} else {
+			res.values[key] = v.Copy()
+		}
+	}
+	return res
+}
+
+func (m *ORMap[K, V]) Copy() *ORMap[K, V] {
+	res := NewORMap[K, V]()
+	res.Merge(m)
+	return res
+}
+
+type orMapEntry[K any, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+type orMapJSON[K comparable, V Mergeable[V]] struct {
+	Keys   *ORSet[K]          `json:"keys"`
+	Values []orMapEntry[K, V] `json:"values"`
+}
+
+// MarshalJSON encodes the map as its keys and a list of keys with their
+// values.
+func (m *ORMap[K, V]) MarshalJSON() ([]byte, error) {
+	v := orMapJSON[K, V]{
+		Keys:   m.keys,
+		Values: make([]orMapEntry[K, V], 0, len(m.values)),
+	}
+	for key, value := range m.values {
+		v.Values = append(v.Values, orMapEntry[K, V]{Key: key, Value: value})
+	}
+	return json.Marshal(v)
+}
+
+func (m *ORMap[K, V]) UnmarshalJSON(data []byte) error {
+	v := orMapJSON[K, V]{Keys: NewORSet[K]()}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	m.keys = v.Keys
+	m.values = make(map[K]V, len(v.Values))
+	for _, entry := range v.Values {
+		m.values[entry.Key] = entry.Value
+	}
+	return nil
+}
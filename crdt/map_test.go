@@ -0,0 +1,26 @@
+package crdt
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestORMap(t *testing.T) {
+	checkProperties(t, spec[*ORMap[string, *PNCounter]]{
+		new: NewORMap[string, *PNCounter],
+		update: func(rng *rand.Rand, node string, x *ORMap[string, *PNCounter]) {
+			key := fmt.Sprintf("k%d", rng.Intn(5))
+			if rng.Intn(3) == 0 {
+				x.Remove(key)
+				return
+			}
+			v := NewPNCounter()
+			if cur, exists := x.Get(key); exists {
+				v = cur.Copy()
+			}
+			v.Add(node, rng.Intn(9)-4)
+			x.Put(node, key, v)
+		},
+	})
+}
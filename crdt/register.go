@@ -0,0 +1,181 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// LWWRegister is a last-writer-wins register: the value with the highest
+// timestamp wins, the ties being broken by the node that wrote it.
+type LWWRegister[T any] struct {
+	Value     T      `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	Node      string `json:"node"`
+}
+
+func NewLWWRegister[T any]() *LWWRegister[T] {
+	return &LWWRegister[T]{}
+}
+
+// Set writes a value on behalf of a replica. The write is ignored if the
+// register holds a more recent value.
+func (r *LWWRegister[T]) Set(node string, value T, timestamp int64) {
+	r.Merge(&LWWRegister[T]{Value: value, Timestamp: timestamp, Node: node})
+}
+
+// newer returns whether r wins over other.
+func (r *LWWRegister[T]) newer(other *LWWRegister[T]) bool {
+	if r.Timestamp != other.Timestamp {
+		return r.Timestamp > other.Timestamp
+	}
+	return r.Node > other.Node
+}
+
+func (r *LWWRegister[T]) Merge(other *LWWRegister[T]) {
+	if other.newer(r) {
+		*r = *other
+	}
+}
+
+// Delta returns the register if it wins over since, otherwise an empty
+// register.
+func (r *LWWRegister[T]) Delta(since *LWWRegister[T]) *LWWRegister[T] {
+	if r.newer(since) {
+		return r.Copy()
+	}
+	return NewLWWRegister[T]()
+}
+
+// Copy returns a copy of the register. The value itself is copied shallowly.
+func (r *LWWRegister[T]) Copy() *LWWRegister[T] {
+	res := *r
+	return &res
+}
+
+type mvEntry[T any] struct {
+	Value T      `json:"value"`
+	Clock VClock `json:"clock"`
+}
+
+// MVRegister is a multi-value register: a write replaces the values it
+// observed, and the concurrent writes are all kept until a later write
+// replaces them.
+type MVRegister[T any] struct {
+	// Sorted by clock so that the equal registers have the same entries
+	entries []mvEntry[T]
+}
+
+func NewMVRegister[T any]() *MVRegister[T] {
+	return &MVRegister[T]{}
+}
+
+// Set writes a value on behalf of a replica.
+func (r *MVRegister[T]) Set(node string, value T) {
+	clock := make(VClock)
+	for _, e := range r.entries {
+		clock.Merge(e.Clock)
+	}
+	clock[node]++
+	r.entries = []mvEntry[T]{{Value: value, Clock: clock}}
+}
+
+// Values returns the concurrent values of the register.
+func (r *MVRegister[T]) Values() []T {
+	res := make([]T, 0, len(r.entries))
+	for _, e := range r.entries {
+		res = append(res, e.Value)
+	}
+	return res
+}
+
+// Merge keeps the entries that aren't replaced by a write of the other
+// register.
+func (r *MVRegister[T]) Merge(other *MVRegister[T]) {
+	var entries []mvEntry[T]
+	for _, e := range r.entries {
+		if !other.covers(e) {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range other.entries {
+		if !r.dominates(e) {
+			entries = append(entries, e)
+		}
+	}
+	r.entries = entries
+	r.sort()
+}
+
+// covers returns whether an entry is replaced by, or equal to, one of the
+// entries of the register.
+func (r *MVRegister[T]) covers(e mvEntry[T]) bool {
+	for _, cur := range r.entries {
+		if cur.Clock.Descends(e.Clock) {
+			return true
+		}
+	}
+	return false
+}
+
+// dominates returns whether an entry is strictly replaced by one of the entries
+// of the register.
+func (r *MVRegister[T]) dominates(e mvEntry[T]) bool {
+	for _, cur := range r.entries {
+		if cur.Clock.Descends(e.Clock) && !cur.Clock.Equal(e.Clock) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MVRegister[T]) sort() {
+	sort.Slice(r.entries, func(i, j int) bool {
+		return r.entries[i].Clock.String() < r.entries[j].Clock.String()
+	})
+}
+
+// Delta returns the entries that since doesn't cover.
+func (r *MVRegister[T]) Delta(since *MVRegister[T]) *MVRegister[T] {
+	res := NewMVRegister[T]()
+	for _, e := range r.entries {
+		if !since.covers(e) {
+			res.entries = append(res.entries, e)
+		}
+	}
+	return res
+}
+
+// Copy returns a copy of the register. The values themselves are copied
+// shallowly.
+func (r *MVRegister[T]) Copy() *MVRegister[T] {
+	res := NewMVRegister[T]()
+	for _, e := range r.entries {
+		res.entries = append(res.entries, mvEntry[T]{Value: e.Value, Clock: e.Clock.Copy()})
+	}
+	return res
+}
+
+// MarshalJSON encodes the register as a list of values with their clocks.
+func (r *MVRegister[T]) MarshalJSON() ([]byte, error) {
+	entries := r.entries
+	if entries == nil {
+		entries = []mvEntry[T]{}
+	}
+	return json.Marshal(entries)
+}
+
+func (r *MVRegister[T]) UnmarshalJSON(data []byte) error {
+	var entries []mvEntry[T]
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	r.entries = nil
+	for _, e := range entries {
+		if e.Clock == nil {
+			e.Clock = make(VClock)
+		}
+		r.entries = append(r.entries, e)
+	}
+	r.sort()
+	return nil
+}
@@ -0,0 +1,27 @@
+package crdt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLWWRegister(t *testing.T) {
+	var clock int64
+	checkProperties(t, spec[*LWWRegister[int]]{
+		new: NewLWWRegister[int],
+		update: func(rng *rand.Rand, node string, x *LWWRegister[int]) {
+			// Some writes share a timestamp to exercise the ties
+			clock++
+			x.Set(node, rng.Intn(100), clock/2)
+		},
+	})
+}
+
+func TestMVRegister(t *testing.T) {
+	checkProperties(t, spec[*MVRegister[int]]{
+		new: NewMVRegister[int],
+		update: func(rng *rand.Rand, node string, x *MVRegister[int]) {
+			x.Set(node, rng.Intn(100))
+		},
+	})
+}
@@ -0,0 +1,283 @@
+package crdt
+
+import "encoding/json"
+
+// GSet is a grow-only set.
+type GSet[T comparable] struct {
+	elements map[T]struct{}
+}
+
+func NewGSet[T comparable]() *GSet[T] {
+	return &GSet[T]{elements: make(map[T]struct{})}
+}
+
+func (s *GSet[T]) Add(e T) {
+	s.elements[e] = struct{}{}
+}
+
+// AddDelta adds an element and returns the delta-mutator: the set of this
+// element.
+func (s *GSet[T]) AddDelta(e T) *GSet[T] {
+	s.Add(e)
+	res := NewGSet[T]()
+	res.Add(e)
+	return res
+}
+
+func (s *GSet[T]) Contains(e T) bool {
+	_, exists := s.elements[e]
+	return exists
+}
+
+func (s *GSet[T]) Len() int {
+	return len(s.elements)
+}
+
+// Elements returns the elements of the set, in no particular order.
+func (s *GSet[T]) Elements() []T {
+	res := make([]T, 0, len(s.elements))
+	for e := range s.elements {
+		res = append(res, e)
+	}
+	return res
+}
+
+// Merge takes the union of two sets.
+func (s *GSet[T]) Merge(other *GSet[T]) {
+	for e := range other.elements {
+		s.elements[e] = struct{}{}
+	}
+}
+
+// Delta returns the elements that since doesn't contain.
+func (s *GSet[T]) Delta(since *GSet[T]) *GSet[T] {
+	res := NewGSet[T]()
+	for e := range s.elements {
+		if !since.Contains(e) {
+			res.Add(e)
+		}
+	}
+	return res
+}
+
+func (s *GSet[T]) Copy() *GSet[T] {
+	res := NewGSet[T]()
+	res.Merge(s)
+	return res
+}
+
+// MarshalJSON encodes the set as a list of elements.
+func (s *GSet[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Elements())
+}
+
+func (s *GSet[T]) UnmarshalJSON(data []byte) error {
+	var elements []T
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	s.elements = make(map[T]struct{}, len(elements))
+	for _, e := range elements {
+		s.Add(e)
+	}
+	return nil
+}
+
+// TwoPSet is a two-phase set: an element can be added and removed, but never
+// added back once removed.
+type TwoPSet[T comparable] struct {
+	Added   *GSet[T] `json:"added"`
+	Removed *GSet[T] `json:"removed"`
+}
+
+func NewTwoPSet[T comparable]() *TwoPSet[T] {
+	return &TwoPSet[T]{Added: NewGSet[T](), Removed: NewGSet[T]()}
+}
+
+func (s *TwoPSet[T]) Add(e T) {
+	s.Added.Add(e)
+}
+
+// Remove removes an element. Removing an element that wasn't added has no
+// effect.
+func (s *TwoPSet[T]) Remove(e T) {
+	if s.Added.Contains(e) {
+		s.Removed.Add(e)
+	}
+}
+
+func (s *TwoPSet[T]) Contains(e T) bool {
+	return s.Added.Contains(e) && !s.Removed.Contains(e)
+}
+
+// Elements returns the elements of the set, in no particular order.
+func (s *TwoPSet[T]) Elements() []T {
+	var res []T
+	for e := range s.Added.elements {
+		if !s.Removed.Contains(e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func (s *TwoPSet[T]) Merge(other *TwoPSet[T]) {
+	s.Added.Merge(other.Added)
+	s.Removed.Merge(other.Removed)
+}
+
+func (s *TwoPSet[T]) Delta(since *TwoPSet[T]) *TwoPSet[T] {
+	return &TwoPSet[T]{Added: s.Added.Delta(since.Added), Removed: s.Removed.Delta(since.Removed)}
+}
+
+func (s *TwoPSet[T]) Copy() *TwoPSet[T] {
+	return &TwoPSet[T]{Added: s.Added.Copy(), Removed: s.Removed.Copy()}
+}
+
+// ORSet is an observed-remove set: each add is tagged with a unique dot, and a
+// remove only removes the dots it observed, so an element added concurrently
+// to its removal stays in the set (add wins). The dots of the removed elements
+// are kept as tombstones.
+type ORSet[T comparable] struct {
+	adds    map[T]map[Dot]struct{}
+	removes map[Dot]struct{}
+	// Number of dots generated by each replica
+	clock VClock
+}
+
+func NewORSet[T comparable]() *ORSet[T] {
+	return &ORSet[T]{
+		adds:    make(map[T]map[Dot]struct{}),
+		removes: make(map[Dot]struct{}),
+		clock:   make(VClock),
+	}
+}
+
+// Add adds an element on behalf of a replica.
+func (s *ORSet[T]) Add(node string, e T) {
+	s.clock[node]++
+	s.addDot(e, Dot{Node: node, Seq: s.clock[node]})
+}
+
+func (s *ORSet[T]) addDot(e T, dot Dot) {
+	dots, exists := s.adds[e]
+	if !exists {
+		dots = make(map[Dot]struct{})
+		s.adds[e] = dots
+	}
+	dots[dot] = struct{}{}
+}
+
+// Remove removes an element, as observed by this replica.
+func (s *ORSet[T]) Remove(e T) {
+	for dot := range s.adds[e] {
+		s.removes[dot] = struct{}{}
+	}
+}
+
+func (s *ORSet[T]) Contains(e T) bool {
+	for dot := range s.adds[e] {
+		if _, removed := s.removes[dot]; !removed {
+			return true
+		}
+	}
+	return false
+}
+
+// Elements returns the elements of the set, in no particular order.
+func (s *ORSet[T]) Elements() []T {
+	var res []T
+	for e := range s.adds {
+		if s.Contains(e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func (s *ORSet[T]) Merge(other *ORSet[T]) {
+	for e, dots := range other.adds {
+		for dot := range dots {
+			s.addDot(e, dot)
+		}
+	}
+	for dot := range other.removes {
+		s.removes[dot] = struct{}{}
+	}
+	s.clock.Merge(other.clock)
+}
+
+// Delta returns the dots that since doesn't have.
+func (s *ORSet[T]) Delta(since *ORSet[T]) *ORSet[T] {
+	res := NewORSet[T]()
+	for e, dots := range s.adds {
+		for dot := range dots {
+			if _, exists := since.adds[e][dot]; !exists {
+				res.addDot(e, dot)
+			}
+		}
+	}
+	for dot := range s.removes {
+		if _, exists := since.removes[dot]; !exists {
+			res.removes[dot] = struct{}{}
+		}
+	}
+	res.clock = s.clock.Delta(since.clock)
+	return res
+}
+
+func (s *ORSet[T]) Copy() *ORSet[T] {
+	res := NewORSet[T]()
+	res.Merge(s)
+	return res
+}
+
+type orSetEntry[T any] struct {
+	Element T     `json:"element"`
+	Dots    []Dot `json:"dots"`
+}
+
+type orSetJSON[T any] struct {
+	Adds    []orSetEntry[T] `json:"adds"`
+	Removes []Dot           `json:"removes"`
+	Clock   VClock          `json:"clock"`
+}
+
+// MarshalJSON encodes the set as a list of elements with their dots, as the
+// elements can't be JSON object keys in general.
+func (s *ORSet[T]) MarshalJSON() ([]byte, error) {
+	v := orSetJSON[T]{
+		Adds:    make([]orSetEntry[T], 0, len(s.adds)),
+		Removes: make([]Dot, 0, len(s.removes)),
+		Clock:   s.clock,
+	}
+	for e, dots := range s.adds {
+		entry := orSetEntry[T]{Element: e}
+		for dot := range dots {
+			entry.Dots = append(entry.Dots, dot)
+		}
+		v.Adds = append(v.Adds, entry)
+	}
+	for dot := range s.removes {
+		v.Removes = append(v.Removes, dot)
+	}
+	return json.Marshal(v)
+}
+
+func (s *ORSet[T]) UnmarshalJSON(data []byte) error {
+	var v orSetJSON[T]
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = *NewORSet[T]()
+	for _, entry := range v.Adds {
+		for _, dot := range entry.Dots {
+			s.addDot(entry.Element, dot)
+		}
+	}
+	for _, dot := range v.Removes {
+		s.removes[dot] = struct{}{}
+	}
+	s.clock.Merge(v.Clock)
+	return nil
+}
@@ -0,0 +1,43 @@
+package crdt
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestGSet(t *testing.T) {
+	checkProperties(t, spec[*GSet[int]]{
+		new: NewGSet[int],
+		update: func(rng *rand.Rand, node string, x *GSet[int]) {
+			x.Add(rng.Intn(20))
+		},
+	})
+}
+
+func TestTwoPSet(t *testing.T) {
+	checkProperties(t, spec[*TwoPSet[int]]{
+		new: NewTwoPSet[int],
+		update: func(rng *rand.Rand, node string, x *TwoPSet[int]) {
+			if rng.Intn(3) == 0 {
+				x.Remove(rng.Intn(20))
+			} else {
+				x.Add(rng.Intn(20))
+			}
+		},
+	})
+}
+
+func TestORSet(t *testing.T) {
+	checkProperties(t, spec[*ORSet[string]]{
+		new: NewORSet[string],
+		update: func(rng *rand.Rand, node string, x *ORSet[string]) {
+			e := fmt.Sprintf("e%d", rng.Intn(10))
+			if rng.Intn(3) == 0 {
+				x.Remove(e)
+			} else {
+				x.Add(node, e)
+			}
+		},
+	})
+}
@@ -0,0 +1,6 @@
+#!/bin/bash
+# Checks the merge properties of the CRDTs on random states, and the
+# convergence of replicas exchanging deltas through a DeltaLog. A failure is
+# reported along with the seed of the random states.
+
+go test "$@" .
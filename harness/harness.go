@@ -0,0 +1,268 @@
+// Package harness runs Maelstrom nodes without Maelstrom, so that the tests can
+// check properties that the Maelstrom workloads don't cover.
+//
+// The nodes are processes of a binary, and a Cluster routes their messages: it
+// delays them, drops those crossing a partition, and emulates lin-kv and seq-kv.
+// The tests act as a client sending requests to the nodes.
+package harness
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Client is the ID of the client sending the requests.
+const Client = "c1"
+
+type message struct {
+	Src  string          `json:"src"`
+	Dest string          `json:"dest"`
+	Body json.RawMessage `json:"body"`
+}
+
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// Cluster is a set of nodes and the network between them.
+type Cluster struct {
+	latency time.Duration
+	stores  map[string]*Store
+
+	mu          sync.Mutex
+	nodes       map[string]*process
+	group       map[string]int
+	partitioned bool
+	dropped     int
+	// Messages sent between the nodes per type, dropped or not
+	sent    map[string]int
+	nextID  int
+	pending map[int]chan map[string]any
+}
+
+// NewCluster returns an empty cluster whose messages are delayed by latency.
+func NewCluster(latency time.Duration) *Cluster {
+	return &Cluster{
+		latency: latency,
+		stores:  map[string]*Store{"lin-kv": newStore(), "seq-kv": newStore()},
+		nodes:   make(map[string]*process),
+		group:   make(map[string]int),
+		sent:    make(map[string]int),
+		pending: make(map[int]chan map[string]any),
+	}
+}
+
+// Build builds the package of the current directory, the node under test, into
+// bin.
+func Build(bin string) error {
+	out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+// Start starts a node running bin. env is added to the environment of the
+// process, so the modes are selected the same way as with Maelstrom. The node
+// still has to be initialized.
+func (c *Cluster) Start(bin, id string, env ...string) error {
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.nodes[id] = &process{cmd: cmd, stdin: stdin}
+	c.mu.Unlock()
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(nil, 64<<20)
+		for scanner.Scan() {
+			line := append([]byte(nil), scanner.Bytes()...)
+			var msg message
+			if err := json.Unmarshal(line, &msg); err != nil {
+				continue
+			}
+			c.route(msg, line)
+		}
+	}()
+	return nil
+}
+
+// Stop kills a node. The key-value stores are kept, so a node started again
+// with the same ID finds its data.
+func (c *Cluster) Stop(id string) {
+	c.mu.Lock()
+	p, exists := c.nodes[id]
+	delete(c.nodes, id)
+	c.mu.Unlock()
+	if !exists {
+		return
+	}
+	_ = p.stdin.Close()
+	_ = p.cmd.Process.Kill()
+	_ = p.cmd.Wait()
+}
+
+// Close kills all the nodes.
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		c.Stop(id)
+	}
+}
+
+// Init sends the init message to each node, and the topology message if
+// topology isn't nil.
+func (c *Cluster) Init(ids []string, topology map[string][]string) error {
+	for _, id := range ids {
+		if _, err := c.RPC(id, map[string]any{"type": "init", "node_id": id, "node_ids": ids}, 5*time.Second); err != nil {
+			return fmt.Errorf("init %s: %w", id, err)
+		}
+		if topology == nil {
+			continue
+		}
+		if _, err := c.RPC(id, map[string]any{"type": "topology", "topology": topology}, 5*time.Second); err != nil {
+			return fmt.Errorf("topology %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// RPC sends a request from the client to a node and waits for its reply. An
+// error reply is returned along with an error.
+func (c *Cluster) RPC(dst string, body map[string]any, timeout time.Duration) (map[string]any, error) {
+	ch := make(chan map[string]any, 1)
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	body["msg_id"] = id
+	line, err := json.Marshal(map[string]any{"src": Client, "dest": dst, "body": body})
+	if err != nil {
+		return nil, err
+	}
+	c.write(dst, line)
+
+	select {
+	case res := <-ch:
+		if res["type"] == "error" {
+			return res, fmt.Errorf("error %v: %v", res["code"], res["text"])
+		}
+		return res, nil
+	case <-time.After(timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("timeout")
+	}
+}
+
+// Store returns a key-value store (lin-kv or seq-kv).
+func (c *Cluster) Store(name string) *Store {
+	return c.stores[name]
+}
+
+// Split partitions the nodes in two random halves.
+func (c *Cluster) Split(rng *rand.Rand, ids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partitioned = true
+	for i, j := range rng.Perm(len(ids)) {
+		c.group[ids[j]] = i % 2
+	}
+}
+
+// Heal removes the partition.
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partitioned = false
+}
+
+// Dropped returns the number of messages dropped by the partitions.
+func (c *Cluster) Dropped() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// Sent returns the number of messages of a type sent between the nodes.
+func (c *Cluster) Sent(typ string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent[typ]
+}
+
+// route handles a message sent by a node.
+func (c *Cluster) route(msg message, line []byte) {
+	if msg.Dest == Client {
+		var body map[string]any
+		_ = json.Unmarshal(msg.Body, &body)
+		id, _ := body["in_reply_to"].(float64)
+		c.mu.Lock()
+		ch, exists := c.pending[int(id)]
+		delete(c.pending, int(id))
+		c.mu.Unlock()
+		if exists {
+			ch <- body
+		}
+		return
+	}
+	if store, exists := c.stores[msg.Dest]; exists {
+		res := store.handle(msg.Body)
+		if res == nil {
+			return
+		}
+		line, _ := json.Marshal(map[string]any{"src": msg.Dest, "dest": msg.Src, "body": res})
+		time.AfterFunc(c.latency, func() { c.write(msg.Src, line) })
+		return
+	}
+
+	var h struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(msg.Body, &h)
+	c.mu.Lock()
+	c.sent[h.Type]++
+	cut := c.partitioned && c.group[msg.Src] != c.group[msg.Dest]
+	if cut {
+		c.dropped++
+	}
+	c.mu.Unlock()
+	if !cut {
+		time.AfterFunc(c.latency, func() { c.write(msg.Dest, line) })
+	}
+}
+
+func (c *Cluster) write(dst string, line []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, exists := c.nodes[dst]; exists {
+		_, _ = p.stdin.Write(append(line, '\n'))
+	}
+}
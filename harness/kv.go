@@ -0,0 +1,89 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Store emulates the read, write and cas operations of a key-value store. The
+// operations are applied in the order they are received, so it's linearizable.
+type Store struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+func newStore() *Store {
+	return &Store{values: make(map[string]any)}
+}
+
+// Get returns the value of a key, decoded into v (e.g., a struct pointer), and
+// whether it exists.
+func (s *Store) Get(key string, v any) (bool, error) {
+	s.mu.Lock()
+	value, exists := s.values[key]
+	s.mu.Unlock()
+	if !exists {
+		return false, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return true, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// handle applies an operation and returns the reply, or nil if the request
+// can't be decoded.
+func (s *Store) handle(body json.RawMessage) map[string]any {
+	var req struct {
+		Type              string `json:"type"`
+		MsgID             int    `json:"msg_id"`
+		Key               any    `json:"key"`
+		Value             any    `json:"value"`
+		From              any    `json:"from"`
+		To                any    `json:"to"`
+		CreateIfNotExists bool   `json:"create_if_not_exists"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+	key := fmt.Sprint(req.Key)
+	res := map[string]any{"in_reply_to": req.MsgID}
+	notFound := map[string]any{"type": "error", "code": 20, "text": "key does not exist"}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, exists := s.values[key]
+	switch req.Type {
+	case "read":
+		if !exists {
+			return merge(res, notFound)
+		}
+		res["type"] = "read_ok"
+		res["value"] = current
+	case "write":
+		s.values[key] = req.Value
+		res["type"] = "write_ok"
+	case "cas":
+		if !exists && !req.CreateIfNotExists {
+			return merge(res, notFound)
+		}
+		if exists && !reflect.DeepEqual(current, req.From) {
+			return merge(res, map[string]any{"type": "error", "code": 22, "text": "current value doesn't match"})
+		}
+		s.values[key] = req.To
+		res["type"] = "cas_ok"
+	default:
+		return merge(res, map[string]any{"type": "error", "code": 10, "text": "unsupported operation"})
+	}
+	return res
+}
+
+func merge(dst, src map[string]any) map[string]any {
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,5 @@
+module github.com/teivah/gossip-glomers/membership
+
+go 1.20
+
+require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20230113211434-22f433519054
@@ -0,0 +1,475 @@
+// Package membership implements a SWIM-style membership protocol and failure
+// detector on top of a Maelstrom node.
+//
+// Every protocol period, a node pings one member (round-robin). If the member
+// doesn't answer, the node asks a few other members to ping it on its behalf
+// (ping-req). If none of them get an answer either, the member is suspected and
+// declared dead if the suspicion isn't refuted in time. A member refutes a
+// suspicion by increasing its incarnation number.
+//
+// The membership updates aren't sent through dedicated messages: they are
+// piggybacked on the pings and on the messages exchanged by the servers.
+package membership
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// piggybackField is the message field containing the membership updates.
+const piggybackField = "swim"
+
+// Status of a member.
+type Status int
+
+const (
+	Alive Status = iota
+	Suspect
+	Dead
+)
+
+func (s Status) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	default:
+		return "dead"
+	}
+}
+
+// Config of the membership protocol.
+type Config struct {
+	// If disabled, all the nodes are considered as live
+	Enabled        bool
+	ProtocolPeriod time.Duration
+	PingTimeout    time.Duration
+	// Number of members asked to ping an unresponsive member
+	IndirectChecks int
+	// Number of protocol periods before a suspected member is declared dead
+	SuspicionPeriods int
+	// Maximum number of updates piggybacked on a message
+	MaxPiggyback int
+}
+
+// ConfigFromEnv returns the default configuration. The protocol is enabled if
+// the MEMBERSHIP environment variable is set to swim.
+func ConfigFromEnv() Config {
+	return Config{
+		Enabled:          os.Getenv("MEMBERSHIP") == "swim",
+		ProtocolPeriod:   time.Second,
+		PingTimeout:      300 * time.Millisecond,
+		IndirectChecks:   3,
+		SuspicionPeriods: 3,
+		MaxPiggyback:     8,
+	}
+}
+
+type update struct {
+	Node        string `json:"node"`
+	Status      Status `json:"status"`
+	Incarnation int    `json:"incarnation"`
+}
+
+type member struct {
+	status      Status
+	incarnation int
+	suspectedAt time.Time
+}
+
+type pendingUpdate struct {
+	update    update
+	transmits int
+}
+
+// Membership is the view of the cluster of a node.
+type Membership struct {
+	n   *maelstrom.Node
+	cfg Config
+
+	mu          sync.Mutex
+	incarnation int
+	members     map[string]*member
+	updates     []*pendingUpdate
+	probeOrder  []string
+	probeIndex  int
+}
+
+// New creates the membership of a node and registers its handlers. It must be
+// called before the node runs.
+func New(n *maelstrom.Node, cfg Config) *Membership {
+	m := &Membership{
+		n:       n,
+		cfg:     cfg,
+		members: make(map[string]*member),
+	}
+	if cfg.Enabled {
+		n.Handle("swim_ping", m.pingHandler)
+		n.Handle("swim_ping_req", m.pingReqHandler)
+	}
+	return m
+}
+
+// Start runs the failure detector. It must be called once the node is
+// initialized.
+func (m *Membership) Start() {
+	if !m.cfg.Enabled {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-time.After(m.cfg.ProtocolPeriod):
+				m.probe()
+				m.checkSuspects()
+			}
+		}
+	}()
+}
+
+// IsLive returns whether a node is neither suspected nor dead.
+func (m *Membership) IsLive(nodeID string) bool {
+	if !m.cfg.Enabled {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, exists := m.members[nodeID]
+	return !exists || mem.status == Alive
+}
+
+// Live returns the nodes that are neither suspected nor dead.
+func (m *Membership) Live() []string {
+	var live []string
+	for _, nodeID := range m.n.NodeIDs() {
+		if m.IsLive(nodeID) {
+			live = append(live, nodeID)
+		}
+	}
+	return live
+}
+
+// Piggyback returns a message body with the pending membership updates
+// attached. body itself isn't modified.
+func (m *Membership) Piggyback(body map[string]any) map[string]any {
+	if !m.cfg.Enabled {
+		return body
+	}
+
+	updates := m.nextUpdates()
+	if len(updates) == 0 {
+		return body
+	}
+	res := make(map[string]any, len(body)+1)
+	for k, v := range body {
+		res[k] = v
+	}
+	res[piggybackField] = updates
+	return res
+}
+
+// Wrap returns a handler applying the membership updates piggybacked on a
+// message before calling h.
+func (m *Membership) Wrap(h maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+	if !m.cfg.Enabled {
+		return h
+	}
+
+	return func(msg maelstrom.Message) error {
+		m.absorb(msg)
+		return h(msg)
+	}
+}
+
+type piggybackMsg struct {
+	Updates     []update `json:"swim"`
+	Incarnation int      `json:"incarnation"`
+	Target      string   `json:"target"`
+}
+
+func (m *Membership) absorb(msg maelstrom.Message) {
+	var body piggybackMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return
+	}
+	for _, u := range body.Updates {
+		m.apply(u)
+	}
+}
+
+func (m *Membership) pingHandler(msg maelstrom.Message) error {
+	m.absorb(msg)
+	return m.n.Reply(msg, m.Piggyback(map[string]any{
+		"type":        "swim_ping_ok",
+		"incarnation": m.ownIncarnation(),
+	}))
+}
+
+func (m *Membership) pingReqHandler(msg maelstrom.Message) error {
+	var body piggybackMsg
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	m.absorb(msg)
+
+	if !m.ping(body.Target, m.cfg.PingTimeout) {
+		return maelstrom.NewRPCError(maelstrom.Timeout, "no ack from "+body.Target)
+	}
+	return m.n.Reply(msg, map[string]any{
+		"type": "swim_ping_req_ok",
+	})
+}
+
+// probe pings the next member and falls back to indirect pings.
+func (m *Membership) probe() {
+	target, exists := m.nextTarget()
+	if !exists {
+		return
+	}
+
+	if m.ping(target, m.cfg.PingTimeout) {
+		return
+	}
+
+	helpers := m.helpers(target)
+	if len(helpers) == 0 {
+		m.suspect(target)
+		return
+	}
+
+	acks := make(chan bool, len(helpers))
+	timeout := m.cfg.ProtocolPeriod - m.cfg.PingTimeout
+	for _, helper := range helpers {
+		helper := helper
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, err := m.n.SyncRPC(ctx, helper, m.Piggyback(map[string]any{
+				"type":   "swim_ping_req",
+				"target": target,
+			}))
+			acks <- err == nil
+		}()
+	}
+	for range helpers {
+		if <-acks {
+			return
+		}
+	}
+	m.suspect(target)
+}
+
+// ping directly pings a member and returns whether it answered.
+func (m *Membership) ping(target string, timeout time.Duration) bool {
+	body := m.Piggyback(map[string]any{
+		"type": "swim_ping",
+	})
+
+	// Make sure a suspected member learns about it so that it can refute
+	m.mu.Lock()
+	if mem, exists := m.members[target]; exists && mem.status != Alive {
+		updates, _ := body[piggybackField].([]update)
+		body[piggybackField] = append(updates, update{
+			Node:        target,
+			Status:      mem.status,
+			Incarnation: mem.incarnation,
+		})
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	res, err := m.n.SyncRPC(ctx, target, body)
+	if err != nil {
+		return false
+	}
+
+	var ack piggybackMsg
+	if err := json.Unmarshal(res.Body, &ack); err != nil {
+		return false
+	}
+	for _, u := range ack.Updates {
+		m.apply(u)
+	}
+	m.apply(update{Node: target, Status: Alive, Incarnation: ack.Incarnation})
+	return true
+}
+
+// nextTarget returns the next member to probe. The members are probed in a
+// random order, reshuffled after each round.
+func (m *Membership) nextTarget() (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.probeIndex >= len(m.probeOrder) {
+		m.probeOrder = m.probeOrder[:0]
+		for _, nodeID := range m.n.NodeIDs() {
+			if nodeID != m.n.ID() {
+				m.probeOrder = append(m.probeOrder, nodeID)
+			}
+		}
+		rand.Shuffle(len(m.probeOrder), func(i, j int) {
+			m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+		})
+		m.probeIndex = 0
+	}
+	if len(m.probeOrder) == 0 {
+		return "", false
+	}
+
+	target := m.probeOrder[m.probeIndex]
+	m.probeIndex++
+	return target, true
+}
+
+// helpers returns random live members to ask for an indirect ping.
+func (m *Membership) helpers(target string) []string {
+	var candidates []string
+	for _, nodeID := range m.Live() {
+		if nodeID != target && nodeID != m.n.ID() {
+			candidates = append(candidates, nodeID)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > m.cfg.IndirectChecks {
+		candidates = candidates[:m.cfg.IndirectChecks]
+	}
+	return candidates
+}
+
+func (m *Membership) suspect(nodeID string) {
+	m.mu.Lock()
+	incarnation := 0
+	if mem, exists := m.members[nodeID]; exists {
+		incarnation = mem.incarnation
+	}
+	m.mu.Unlock()
+
+	m.apply(update{Node: nodeID, Status: Suspect, Incarnation: incarnation})
+}
+
+// checkSuspects declares dead the members suspected for too long.
+func (m *Membership) checkSuspects() {
+	deadline := time.Duration(m.cfg.SuspicionPeriods) * m.cfg.ProtocolPeriod
+
+	m.mu.Lock()
+	var dead []update
+	for nodeID, mem := range m.members {
+		if mem.status == Suspect && time.Since(mem.suspectedAt) > deadline {
+			dead = append(dead, update{Node: nodeID, Status: Dead, Incarnation: mem.incarnation})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, u := range dead {
+		m.apply(u)
+	}
+}
+
+// apply merges an update into the view and queues it for dissemination if it
+// changed anything.
+func (m *Membership) apply(u update) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u.Node == m.n.ID() {
+		if u.Status != Alive && u.Incarnation >= m.incarnation {
+			// Refute the suspicion
+			m.incarnation = u.Incarnation + 1
+			m.enqueue(update{Node: u.Node, Status: Alive, Incarnation: m.incarnation})
+		}
+		return
+	}
+
+	mem, exists := m.members[u.Node]
+	if !exists {
+		mem = &member{status: Alive}
+		m.members[u.Node] = mem
+	}
+	if !overrides(u, mem) {
+		return
+	}
+
+	mem.status = u.Status
+	mem.incarnation = u.Incarnation
+	if u.Status == Suspect {
+		mem.suspectedAt = time.Now()
+	}
+	m.enqueue(u)
+}
+
+// overrides returns whether an update takes precedence over the current state
+// of a member.
+func overrides(u update, mem *member) bool {
+	switch u.Status {
+	case Alive:
+		return u.Incarnation > mem.incarnation
+	case Suspect:
+		return (mem.status == Alive && u.Incarnation >= mem.incarnation) ||
+			u.Incarnation > mem.incarnation
+	default:
+		return (mem.status != Dead && u.Incarnation >= mem.incarnation) ||
+			u.Incarnation > mem.incarnation
+	}
+}
+
+// enqueue must be called with the lock held.
+func (m *Membership) enqueue(u update) {
+	for i, pending := range m.updates {
+		if pending.update.Node == u.Node {
+			m.updates = append(m.updates[:i], m.updates[i+1:]...)
+			break
+		}
+	}
+	m.updates = append(m.updates, &pendingUpdate{update: u})
+}
+
+// nextUpdates returns the updates to piggyback, the least transmitted first.
+// An update is transmitted about 3*log(n) times.
+func (m *Membership) nextUpdates() []update {
+	maxTransmits := 3 * int(math.Ceil(math.Log2(float64(len(m.n.NodeIDs())+1))))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sort.SliceStable(m.updates, func(i, j int) bool {
+		return m.updates[i].transmits < m.updates[j].transmits
+	})
+
+	var updates []update
+	for _, pending := range m.updates {
+		if len(updates) == m.cfg.MaxPiggyback {
+			break
+		}
+		updates = append(updates, pending.update)
+		pending.transmits++
+	}
+
+	remaining := m.updates[:0]
+	for _, pending := range m.updates {
+		if pending.transmits < maxTransmits {
+			remaining = append(remaining, pending)
+		}
+	}
+	m.updates = remaining
+	return updates
+}
+
+func (m *Membership) ownIncarnation() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.incarnation
+}
@@ -0,0 +1,3 @@
+module github.com/teivah/gossip-glomers/payload
+
+go 1.20
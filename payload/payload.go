@@ -0,0 +1,83 @@
+// Package payload identifies the values broadcast by the servers. A value can
+// be any JSON value (number, string, object, etc.), and the servers deduplicate
+// and track the values by an integer ID:
+//   - An integer is its own ID, so the integer workloads are unchanged
+//   - Otherwise, the ID is the hash of the id field provided by the client or,
+//     if there's none, the hash of the canonical JSON encoding of the value
+//
+// The hashes are truncated to 53 bits so that an ID can be safely decoded as a
+// float64 (the default JSON number type). A collision with another value is
+// possible but negligible with the volumes at stake.
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+const (
+	// KeyField is the field used by the nodes to send a value along with its
+	// ID, when the ID can't be derived from the value itself (client-provided
+	// id). It's namespaced so that it doesn't collide with the fields of the
+	// clients.
+	KeyField = "_payload_id"
+	// IDField is the field containing the ID provided by a client.
+	IDField = "id"
+
+	maxSafeInteger = 1<<53 - 1
+)
+
+// ID returns the ID of the value of a broadcast body (message field).
+func ID(body map[string]any) (int, error) {
+	if key, exists := body[KeyField]; exists {
+		id, ok := integer(key)
+		if !ok {
+			return 0, errors.New("invalid " + KeyField)
+		}
+		return id, nil
+	}
+	if id, exists := body[IDField]; exists {
+		return hash("id", id)
+	}
+
+	message, exists := body["message"]
+	if !exists {
+		return 0, errors.New("missing message")
+	}
+	if id, ok := integer(message); ok {
+		return id, nil
+	}
+	return hash("message", message)
+}
+
+// Inline returns whether a value is its own ID, in which case it doesn't have to
+// be stored or sent besides its ID.
+func Inline(message any, id int) bool {
+	v, ok := integer(message)
+	return ok && v == id
+}
+
+func integer(message any) (int, bool) {
+	f, ok := message.(float64)
+	if !ok || f != math.Trunc(f) || math.Abs(f) > maxSafeInteger {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// hash returns the hash of the canonical JSON encoding of v. The keys of the
+// JSON objects are sorted by encoding/json, so all the nodes get the same
+// encoding.
+func hash(kind string, v any) (int, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(kind))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(b)
+	return int(h.Sum64() & maxSafeInteger), nil
+}
@@ -0,0 +1,117 @@
+package topology
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stats describes a broadcast graph.
+type Stats struct {
+	Nodes     int
+	Edges     int
+	MaxDegree int
+	// Diameter is the maximum number of hops between two nodes, or -1 if the
+	// graph isn't connected
+	Diameter int
+	// MsgsPerBroadcast is the number of messages exchanged between the nodes to
+	// disseminate a single value without batching: each node forwards the value
+	// to all its neighbors but the one it received it from, and each forward is
+	// acknowledged
+	MsgsPerBroadcast int
+}
+
+// MsgsPerOp returns the expected messages-per-operation reported by Maelstrom,
+// which also counts the reads (about half the operations of the broadcast
+// workload).
+func (s Stats) MsgsPerOp() float64 {
+	return float64(s.MsgsPerBroadcast) / 2
+}
+
+// NodeIDs returns the IDs assigned by Maelstrom to n nodes.
+func NodeIDs(n int) []string {
+	nodeIDs := make([]string, n)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("n%d", i)
+	}
+	return nodeIDs
+}
+
+// Analyze returns the stats of the graph of the given nodes.
+func Analyze(g Graph, nodeIDs []string) Stats {
+	s := Stats{Nodes: len(nodeIDs)}
+	degrees := 0
+	for _, nodeID := range nodeIDs {
+		degree := len(g.Neighbors(nodeID))
+		degrees += degree
+		if degree > s.MaxDegree {
+			s.MaxDegree = degree
+		}
+	}
+	s.Edges = degrees / 2
+
+	for _, nodeID := range nodeIDs {
+		eccentricity, ok := g.eccentricity(nodeID, len(nodeIDs))
+		if !ok {
+			s.Diameter = -1
+			break
+		}
+		if eccentricity > s.Diameter {
+			s.Diameter = eccentricity
+		}
+	}
+
+	// The origin forwards to all its neighbors, the other nodes to all their
+	// neighbors but one
+	if len(nodeIDs) != 0 {
+		s.MsgsPerBroadcast = 2 * (degrees - (len(nodeIDs) - 1))
+	}
+	return s
+}
+
+// eccentricity returns the maximum number of hops from a node to the others,
+// and false if some of the n nodes can't be reached.
+func (g Graph) eccentricity(nodeID string, n int) (int, bool) {
+	hops := map[string]int{nodeID: 0}
+	queue := []string{nodeID}
+	max := 0
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, neighbor := range g[cur] {
+			if _, visited := hops[neighbor]; visited {
+				continue
+			}
+			hops[neighbor] = hops[cur] + 1
+			if hops[neighbor] > max {
+				max = hops[neighbor]
+			}
+			queue = append(queue, neighbor)
+		}
+	}
+	return max, len(hops) == n
+}
+
+// DOT returns the Graphviz representation of the graph of the given nodes.
+func (g Graph) DOT(name string, nodeIDs []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "graph %q {\n", name)
+	for _, nodeID := range sortedNodeIDs(nodeIDs) {
+		fmt.Fprintf(&sb, "\t%q;\n", nodeID)
+	}
+	for _, nodeID := range sortedNodeIDs(nodeIDs) {
+		for _, neighbor := range g.Neighbors(nodeID) {
+			// Each edge is only written once
+			if less(nodeID, neighbor) {
+				fmt.Fprintf(&sb, "\t%q -- %q;\n", nodeID, neighbor)
+			}
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func less(a, b string) bool {
+	nodes := []string{b, a}
+	sortNodeIDs(nodes)
+	return nodes[0] == a
+}
@@ -0,0 +1,63 @@
+// Command graph prints the broadcast graph built by #3d and #3e for a given
+// number of nodes and strategy, along with its stats, and can export it to
+// Graphviz DOT:
+//
+//	go run ./cmd/graph -nodes 25 -strategy flat-tree -dot ../res/tree.dot
+//	dot -Tpng ../res/tree.dot -o ../res/tree.png
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/teivah/gossip-glomers/topology"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 25, "number of nodes")
+	strategy := flag.String("strategy", topology.FlatTree, "topology strategy (same values as BROADCAST_TOPOLOGY)")
+	degree := flag.Int("degree", 0, "degree (same as BROADCAST_TOPOLOGY_DEGREE)")
+	dot := flag.String("dot", "", "file to export the graph to in DOT format (- for stdout)")
+	flag.Parse()
+
+	if *strategy == topology.Provided {
+		// The graph depends on the topology message sent by Maelstrom
+		log.Fatalf("the %s strategy can't be computed offline", topology.Provided)
+	}
+	s, err := topology.New(*strategy, *degree)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nodeIDs := topology.NodeIDs(*nodes)
+	g, err := s.Build(nodeIDs, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *dot != "" {
+		out := g.DOT(s.Name(), nodeIDs)
+		if *dot == "-" {
+			fmt.Print(out)
+			return
+		}
+		if err := os.WriteFile(*dot, []byte(out), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	stats := topology.Analyze(g, nodeIDs)
+	fmt.Printf("strategy:           %s\n", s.Name())
+	fmt.Printf("nodes:              %d\n", stats.Nodes)
+	fmt.Printf("edges:              %d\n", stats.Edges)
+	fmt.Printf("max degree:         %d\n", stats.MaxDegree)
+	if stats.Diameter < 0 {
+		fmt.Printf("diameter:           disconnected\n")
+	} else {
+		fmt.Printf("diameter:           %d\n", stats.Diameter)
+	}
+	fmt.Printf("msgs per broadcast: %d\n", stats.MsgsPerBroadcast)
+	fmt.Printf("msgs per op:        %.2f\n", stats.MsgsPerOp())
+}
@@ -0,0 +1,5 @@
+module github.com/teivah/gossip-glomers/topology
+
+go 1.20
+
+require github.com/emirpasic/gods v1.18.1
@@ -0,0 +1,380 @@
+// Package topology computes the graph used by the broadcast servers to decide
+// which nodes a message is forwarded to.
+package topology
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/emirpasic/gods/trees/btree"
+)
+
+const (
+	Provided      = "provided"
+	FlatTree      = "flat-tree"
+	KAryTree      = "k-ary-tree"
+	Ring          = "ring"
+	Grid          = "grid"
+	RandomRegular = "random-regular"
+
+	defaultDegree = 4
+	// All the nodes have to build the same random graph, so the seed is fixed
+	randomSeed = 42
+	// Number of edge switches per edge when randomizing a graph
+	switchesPerEdge = 10
+)
+
+// Graph maps a node ID to the sorted IDs of its neighbors.
+type Graph map[string][]string
+
+// Neighbors returns the neighbors of a node.
+func (g Graph) Neighbors(nodeID string) []string {
+	return g[nodeID]
+}
+
+func (g Graph) addEdge(a, b string) {
+	if a == b {
+		return
+	}
+	g.addArc(a, b)
+	g.addArc(b, a)
+}
+
+func (g Graph) removeEdge(a, b string) {
+	g.removeArc(a, b)
+	g.removeArc(b, a)
+}
+
+func (g Graph) removeArc(src, dst string) {
+	for i, v := range g[src] {
+		if v == dst {
+			g[src] = append(g[src][:i], g[src][i+1:]...)
+			return
+		}
+	}
+}
+
+func (g Graph) addArc(src, dst string) {
+	for _, v := range g[src] {
+		if v == dst {
+			return
+		}
+	}
+	g[src] = append(g[src], dst)
+}
+
+func (g Graph) sort() {
+	for k := range g {
+		sortNodeIDs(g[k])
+	}
+}
+
+// Strategy builds the broadcast graph. Every node must build the same graph
+// from the same inputs.
+type Strategy interface {
+	Name() string
+	// Build returns the graph for the given nodes. provided is the topology
+	// sent by Maelstrom in the topology message. It returns an error if the
+	// graph can't be built for this number of nodes.
+	Build(nodeIDs []string, provided map[string][]string) (Graph, error)
+}
+
+// New returns the strategy with the given name. degree is the number of
+// children per node for k-ary-tree and the number of neighbors per node for
+// random-regular; it's ignored by the other strategies.
+func New(name string, degree int) (Strategy, error) {
+	if degree <= 0 {
+		degree = defaultDegree
+	}
+
+	switch name {
+	case Provided:
+		return providedStrategy{}, nil
+	case FlatTree, "":
+		return flatTreeStrategy{}, nil
+	case KAryTree:
+		return kAryTreeStrategy{k: degree}, nil
+	case Ring:
+		return ringStrategy{}, nil
+	case Grid:
+		return gridStrategy{}, nil
+	case RandomRegular:
+		return randomRegularStrategy{degree: degree}, nil
+	default:
+		return nil, fmt.Errorf("unknown topology strategy: %q", name)
+	}
+}
+
+// FromEnv returns the strategy configured by the BROADCAST_TOPOLOGY and
+// BROADCAST_TOPOLOGY_DEGREE environment variables (flat-tree by default).
+func FromEnv() (Strategy, error) {
+	degree := 0
+	if v := os.Getenv("BROADCAST_TOPOLOGY_DEGREE"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BROADCAST_TOPOLOGY_DEGREE: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid BROADCAST_TOPOLOGY_DEGREE: %d, must be positive", d)
+		}
+		degree = d
+	}
+	return New(os.Getenv("BROADCAST_TOPOLOGY"), degree)
+}
+
+type providedStrategy struct{}
+
+func (providedStrategy) Name() string {
+	return Provided
+}
+
+func (providedStrategy) Build(nodeIDs []string, provided map[string][]string) (Graph, error) {
+	g := make(Graph, len(nodeIDs))
+	for src, neighbors := range provided {
+		for _, dst := range neighbors {
+			g.addEdge(src, dst)
+		}
+	}
+	g.sort()
+	return g, nil
+}
+
+// flatTreeStrategy is a two-level tree: one root and the rest are children.
+type flatTreeStrategy struct{}
+
+func (flatTreeStrategy) Name() string {
+	return FlatTree
+}
+
+func (flatTreeStrategy) Build(nodeIDs []string, _ map[string][]string) (Graph, error) {
+	nodes := sortedNodeIDs(nodeIDs)
+	g := make(Graph, len(nodes))
+	if len(nodes) < 3 {
+		// A btree requires an order of at least 3
+		for i := 1; i < len(nodes); i++ {
+			g.addEdge(nodes[0], nodes[i])
+		}
+		return g, nil
+	}
+
+	tree := btree.NewWithIntComparator(len(nodes))
+	for i, nodeID := range nodes {
+		tree.Put(i, nodeID)
+	}
+
+	for i, nodeID := range nodes {
+		n := tree.GetNode(i)
+		if n.Parent != nil {
+			g.addEdge(nodeID, n.Parent.Entries[0].Value.(string))
+		}
+		for _, children := range n.Children {
+			for _, entry := range children.Entries {
+				g.addEdge(nodeID, entry.Value.(string))
+			}
+		}
+	}
+	g.sort()
+	return g, nil
+}
+
+type kAryTreeStrategy struct {
+	k int
+}
+
+func (kAryTreeStrategy) Name() string {
+	return KAryTree
+}
+
+func (s kAryTreeStrategy) Build(nodeIDs []string, _ map[string][]string) (Graph, error) {
+	nodes := sortedNodeIDs(nodeIDs)
+	g := make(Graph, len(nodes))
+	for i := 1; i < len(nodes); i++ {
+		g.addEdge(nodes[i], nodes[(i-1)/s.k])
+	}
+	g.sort()
+	return g, nil
+}
+
+type ringStrategy struct{}
+
+func (ringStrategy) Name() string {
+	return Ring
+}
+
+func (ringStrategy) Build(nodeIDs []string, _ map[string][]string) (Graph, error) {
+	nodes := sortedNodeIDs(nodeIDs)
+	g := make(Graph, len(nodes))
+	for i := range nodes {
+		g.addEdge(nodes[i], nodes[(i+1)%len(nodes)])
+	}
+	g.sort()
+	return g, nil
+}
+
+// gridStrategy places the nodes on a square grid where each node is connected
+// to its left, right, top and bottom neighbors.
+type gridStrategy struct{}
+
+func (gridStrategy) Name() string {
+	return Grid
+}
+
+func (gridStrategy) Build(nodeIDs []string, _ map[string][]string) (Graph, error) {
+	nodes := sortedNodeIDs(nodeIDs)
+	g := make(Graph, len(nodes))
+	cols := int(math.Ceil(math.Sqrt(float64(len(nodes)))))
+	for i := range nodes {
+		if (i+1)%cols != 0 && i+1 < len(nodes) {
+			g.addEdge(nodes[i], nodes[i+1])
+		}
+		if i+cols < len(nodes) {
+			g.addEdge(nodes[i], nodes[i+cols])
+		}
+	}
+	g.sort()
+	return g, nil
+}
+
+// randomRegularStrategy builds a connected graph where each node has the same
+// number of neighbors. It starts from a circulant graph, which is regular and
+// connected, and randomizes it with degree-preserving edge switches, using a
+// fixed seed.
+type randomRegularStrategy struct {
+	degree int
+}
+
+func (randomRegularStrategy) Name() string {
+	return RandomRegular
+}
+
+func (s randomRegularStrategy) Build(nodeIDs []string, _ map[string][]string) (Graph, error) {
+	nodes := sortedNodeIDs(nodeIDs)
+	if s.degree > len(nodes)-1 {
+		return nil, fmt.Errorf("a %s graph of degree %d requires at least %d nodes, got %d", RandomRegular, s.degree, s.degree+1, len(nodes))
+	}
+	if s.degree*len(nodes)%2 != 0 {
+		return nil, fmt.Errorf("a %s graph of odd degree %d requires an even number of nodes, got %d", RandomRegular, s.degree, len(nodes))
+	}
+	if s.degree < 2 && len(nodes) > 2 {
+		return nil, fmt.Errorf("a %s graph of degree %d can't connect %d nodes", RandomRegular, s.degree, len(nodes))
+	}
+
+	g := circulant(nodes, s.degree)
+	randomize(rand.New(rand.NewSource(randomSeed)), g, nodes)
+	g.sort()
+	return g, nil
+}
+
+// circulant connects the node i to the nodes i±1, ..., i±degree/2, and if the
+// degree is odd (hence the number of nodes is even), to the opposite node.
+func circulant(nodes []string, degree int) Graph {
+	n := len(nodes)
+	g := make(Graph, n)
+	for i := range nodes {
+		for k := 1; k <= degree/2; k++ {
+			g.addEdge(nodes[i], nodes[(i+k)%n])
+		}
+		if degree%2 != 0 {
+			g.addEdge(nodes[i], nodes[(i+n/2)%n])
+		}
+	}
+	return g
+}
+
+// randomize applies random edge switches: two edges a-b and c-d become a-d and
+// c-b, which preserves the degrees. A switch is skipped if it creates a loop or
+// a duplicate edge, and undone if it disconnects the graph.
+func randomize(rng *rand.Rand, g Graph, nodes []string) {
+	type edge struct{ a, b string }
+	var edges []edge
+	for _, a := range nodes {
+		for _, b := range g[a] {
+			if a < b {
+				edges = append(edges, edge{a, b})
+			}
+		}
+	}
+	if len(edges) < 2 {
+		return
+	}
+
+	for i := 0; i < switchesPerEdge*len(edges); i++ {
+		x, y := rng.Intn(len(edges)), rng.Intn(len(edges))
+		if x == y {
+			continue
+		}
+		a, b := edges[x].a, edges[x].b
+		c, d := edges[y].a, edges[y].b
+		if rng.Intn(2) == 0 {
+			c, d = d, c
+		}
+		if a == c || a == d || b == c || b == d || contains(g[a], d) || contains(g[c], b) {
+			continue
+		}
+
+		g.removeEdge(a, b)
+		g.removeEdge(c, d)
+		g.addEdge(a, d)
+		g.addEdge(c, b)
+		if !connected(g, nodes) {
+			g.removeEdge(a, d)
+			g.removeEdge(c, b)
+			g.addEdge(a, b)
+			g.addEdge(c, d)
+			continue
+		}
+		edges[x] = edge{a, d}
+		edges[y] = edge{c, b}
+	}
+}
+
+func connected(g Graph, nodes []string) bool {
+	if len(nodes) == 0 {
+		return true
+	}
+	visited := map[string]bool{nodes[0]: true}
+	queue := []string{nodes[0]}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, neighbor := range g[cur] {
+			if !visited[neighbor] {
+				visited[neighbor] = true
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return len(visited) == len(nodes)
+}
+
+func contains(nodeIDs []string, nodeID string) bool {
+	for _, v := range nodeIDs {
+		if v == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNodeIDs(nodeIDs []string) []string {
+	nodes := make([]string, len(nodeIDs))
+	copy(nodes, nodeIDs)
+	sortNodeIDs(nodes)
+	return nodes
+}
+
+// sortNodeIDs sorts node IDs (e.g., n2, n10) by their numeric part.
+func sortNodeIDs(nodeIDs []string) {
+	sort.Slice(nodeIDs, func(i, j int) bool {
+		a, errA := strconv.Atoi(nodeIDs[i][1:])
+		b, errB := strconv.Atoi(nodeIDs[j][1:])
+		if errA != nil || errB != nil {
+			return nodeIDs[i] < nodeIDs[j]
+		}
+		return a < b
+	})
+}